@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// account is a TUCaN login with its own updater, storage and feed tokens.
+// Accounts don't share any state, so one failing login doesn't affect others.
+type account struct {
+	name     string
+	username *secretValue
+	password *secretValue
+	totpSeed *secretValue
+	totpID   *secretValue
+	schedule *schedule
+	auth     feedAuth
+	// May use the API for this account, unlike the feed tokens
+	apiTokens *secretList
+	dataDir   string
+	// The merged calendar, data_dir/<name>/merged_calendar.ics if empty
+	calendarFile string
+	log          *log.Logger
+	refresh      *refreshState
+	changes      *changeLog
+
+	calendar  calendarConfig
+	reminders *reminders
+	alarms    []alarmRule
+	rewrites  []rewriteRule
+	rooms     roomDB
+	conflicts conflictConfig
+	exams     *examConfig
+	deadlines *deadlineConfig
+	grades    *gradeConfig
+	// The deadlines of each details page, only used by the updater
+	deadlinePages map[string]deadlinePage
+	// Signaled after the calendar file was written
+	calendarUpdated chan struct{}
+
+	notifiers  []notifier
+	deliveries *deliveryLog
+	notifying  sync.WaitGroup
+	// Guards notifying.Add against the Wait of flushNotifications, after
+	// which notifications are dropped
+	notifyMu sync.Mutex
+	flushed  bool
+
+	lastNewestCalendarGetOK atomic.Bool
+
+	sourcesMu sync.Mutex
+	// The source of each month of the stored calendar, see tucanData
+	sources map[string]string
+}
+
+func newAccount(name, dataDir string) *account {
+	return &account{
+		name:    name,
+		dataDir: dataDir,
+		log:     log.New(os.Stderr, "["+name+"] ", log.LstdFlags|log.Lmsgprefix),
+		refresh: newRefreshState(),
+		changes: newChangeLog(filepath.Join(dataDir, changesFile)),
+
+		calendarUpdated: make(chan struct{}, 1),
+
+		deliveries: newDeliveryLog(filepath.Join(dataDir, deliveriesFile)),
+	}
+}
+
+func (a *account) icalPath() string {
+	if a.calendarFile != "" {
+		return a.calendarFile
+	}
+	return filepath.Join(a.dataDir, icalFile)
+}
+
+func (a *account) originalPath() string {
+	return filepath.Join(a.dataDir, originalFile)
+}
+
+// Read and parse the stored calendar, an empty one if there is none yet
+func (a *account) readCalendar() (*icalComponent, error) {
+	data, err := os.ReadFile(a.icalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return &icalComponent{name: "VCALENDAR"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseICalendar(string(data))
+}
+
+func (a *account) setMonthSources(sources map[string]string) {
+	a.sourcesMu.Lock()
+	defer a.sourcesMu.Unlock()
+	a.sources = maps.Clone(sources)
+}
+
+// Return the months of the stored calendar in order with their sources
+func (a *account) monthSources() []monthSource {
+	a.sourcesMu.Lock()
+	defer a.sourcesMu.Unlock()
+	var sources []monthSource
+	for _, month := range slices.Sorted(maps.Keys(a.sources)) {
+		sources = append(sources, monthSource{Month: month, Source: a.sources[month]})
+	}
+	return sources
+}
+
+// Return the conflicts that overlap [from, to), either may be zero
+func (a *account) conflictsBetween(from, to time.Time) ([]conflict, error) {
+	cal, err := a.readCalendar()
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []conflict
+	for _, c := range findConflicts(calendarEvents(cal), a.rooms, time.Duration(a.conflicts.TravelTime)) {
+		if (from.IsZero() || c.End.After(from)) && (to.IsZero() || c.Start.Before(to)) {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// alarmConfig adds VALARMs to the events it matches. Events match when every
+// given selector matches, a rule without selectors matches every event.
+type alarmConfig struct {
+	// Regular expressions on the event title, any of them may match
+	Courses []string `yaml:"courses,omitempty"`
+	// lecture, exercise, exam or deadline
+	Kinds []string `yaml:"kinds,omitempty"`
+	// Case insensitive words in the title, any of them may match
+	Keywords []string `yaml:"keywords,omitempty"`
+	// How long before the start the alarms go off
+	Before []duration `yaml:"before"`
+}
+
+type alarmRule struct {
+	courses  []*regexp.Regexp
+	kinds    []string
+	keywords []string
+	before   []time.Duration
+}
+
+// Parse the alarm rules, errors start with the index like "[0].before: …"
+func newAlarmRules(cfgs []alarmConfig) ([]alarmRule, error) {
+	var rules []alarmRule
+	var errs []error
+	for i, cfg := range cfgs {
+		rule := alarmRule{kinds: cfg.Kinds}
+		for _, course := range cfg.Courses {
+			re, err := regexp.Compile(course)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("[%d].courses: %w", i, err))
+				continue
+			}
+			rule.courses = append(rule.courses, re)
+		}
+		for _, kind := range cfg.Kinds {
+			if !validEventKind(kind) {
+				errs = append(errs, fmt.Errorf("[%d].kinds: unknown kind %q, use one of %v", i, kind, eventKinds))
+			}
+		}
+		for _, keyword := range cfg.Keywords {
+			rule.keywords = append(rule.keywords, strings.ToLower(keyword))
+		}
+		if len(cfg.Before) == 0 {
+			errs = append(errs, fmt.Errorf("[%d].before: at least one time is required", i))
+		}
+		for _, before := range cfg.Before {
+			if before < 0 {
+				errs = append(errs, fmt.Errorf("[%d].before: must not be negative", i))
+			} else if time.Duration(before)%time.Second != 0 {
+				errs = append(errs, fmt.Errorf("[%d].before: %v is not a whole number of seconds", i, time.Duration(before)))
+			}
+			rule.before = append(rule.before, time.Duration(before))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errors.Join(errs...)
+}
+
+// Parse the alarm query parameter of the feed, e.g. "15m,1h"
+func parseAlarmQuery(values []string) ([]alarmRule, error) {
+	var rule alarmRule
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			before, err := time.ParseDuration(strings.TrimSpace(field))
+			if err != nil || before < 0 || before%time.Second != 0 {
+				return nil, fmt.Errorf("invalid alarm %q, use durations like 15m or 1h", field)
+			}
+			rule.before = append(rule.before, before)
+		}
+	}
+	return []alarmRule{rule}, nil
+}
+
+func (r alarmRule) matches(summary, kind string) bool {
+	if len(r.courses) > 0 && !matchesAny(r.courses, summary) {
+		return false
+	}
+	if len(r.kinds) > 0 {
+		found := false
+		for _, k := range r.kinds {
+			found = found || k == kind
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.keywords) > 0 {
+		lower := strings.ToLower(summary)
+		found := false
+		for _, keyword := range r.keywords {
+			found = found || strings.Contains(lower, keyword)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(patterns []*regexp.Regexp, value string) bool {
+	for _, re := range patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a display VALARM per matching rule and time to every event. Alarms with
+// the same trigger as an existing one are skipped.
+func addAlarms(cal *icalComponent, rules []alarmRule) {
+	for _, event := range cal.children("VEVENT") {
+		summary := unescapeText(event.value("SUMMARY"))
+		// The kind comes from the course type, which rewritten titles don't change
+		kind := eventCourseInfo(event).kind()
+		triggers := make(map[string]bool)
+		for _, alarm := range event.children("VALARM") {
+			triggers[alarm.value("TRIGGER")] = true
+		}
+		for _, rule := range rules {
+			if !rule.matches(summary, kind) {
+				continue
+			}
+			for _, before := range rule.before {
+				trigger := formatICalDuration(-before)
+				if triggers[trigger] {
+					continue
+				}
+				triggers[trigger] = true
+				event.components = append(event.components, displayAlarm(event, trigger))
+			}
+		}
+	}
+}
+
+// Check the alarms of the exams or deadlines, given as how long before the
+// event they go off
+func validateAlarmTimes(alarms []duration) error {
+	for _, before := range alarms {
+		if before < 0 {
+			return errors.New("alarms: must not be negative")
+		}
+		if time.Duration(before)%time.Second != 0 {
+			return fmt.Errorf("alarms: %v is not a whole number of seconds", time.Duration(before))
+		}
+	}
+	return nil
+}
+
+// Add a display VALARM per time to an exam or deadline event
+func addEventAlarms(event *icalComponent, alarms []duration) {
+	for _, before := range alarms {
+		event.components = append(event.components, displayAlarm(event, formatICalDuration(-time.Duration(before))))
+	}
+}
+
+// Return a VALARM showing the event's title at trigger
+func displayAlarm(event *icalComponent, trigger string) *icalComponent {
+	return &icalComponent{
+		name: "VALARM",
+		properties: []*icalProperty{
+			{name: "ACTION", value: "DISPLAY"},
+			{name: "DESCRIPTION", value: event.value("SUMMARY")},
+			{name: "TRIGGER", value: trigger},
+		},
+	}
+}
+
+// Format a duration as an iCalendar DURATION value like -PT15M or -P1DT1H
+func formatICalDuration(d time.Duration) string {
+	// iCalendar durations end at seconds
+	d = d.Truncate(time.Second)
+	var b strings.Builder
+	if d < 0 {
+		b.WriteByte('-')
+		d = -d
+	}
+	b.WriteByte('P')
+	days := d / (24 * time.Hour)
+	if days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+		d -= days * 24 * time.Hour
+	}
+	if d == 0 {
+		if days == 0 {
+			b.WriteString("T0S")
+		}
+		return b.String()
+	}
+	b.WriteByte('T')
+	if hours := d / time.Hour; hours > 0 {
+		fmt.Fprintf(&b, "%dH", hours)
+		d -= hours * time.Hour
+	}
+	if minutes := d / time.Minute; minutes > 0 {
+		fmt.Fprintf(&b, "%dM", minutes)
+		d -= minutes * time.Minute
+	}
+	if seconds := d / time.Second; seconds > 0 {
+		fmt.Fprintf(&b, "%dS", seconds)
+	}
+	return b.String()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func alarmTriggers(t *testing.T, cal *icalComponent) map[string][]string {
+	t.Helper()
+	triggers := make(map[string][]string)
+	for _, event := range cal.children("VEVENT") {
+		for _, alarm := range event.children("VALARM") {
+			triggers[event.value("SUMMARY")] = append(triggers[event.value("SUMMARY")], alarm.value("TRIGGER"))
+		}
+	}
+	return triggers
+}
+
+func TestAddAlarms(t *testing.T) {
+	rules, err := newAlarmRules([]alarmConfig{
+		{Kinds: []string{kindExam}, Before: []duration{duration(24 * time.Hour), duration(time.Hour)}},
+		{Courses: []string{"^01-10-0001"}, Before: []duration{duration(15 * time.Minute)}},
+		{Keywords: []string{"TUTORIUM"}, Before: []duration{duration(90 * time.Minute)}},
+		// Same trigger as the course rule, added once
+		{Kinds: []string{kindLecture}, Keywords: []string{"analysis"}, Before: []duration{duration(15 * time.Minute)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cal, err := parseICalendar(changesTestCalendar(
+		"1~01-10-0001-vl Analysis I~S1|01 A1~20251020T081500~20251020T095500",
+		"2~Klausur Analysis I~S1|01 A1~20251021T100000~20251021T120000",
+		"3~Tutorium Lineare Algebra~S2|02 C110~20251022T100000~20251022T110000",
+		"4~20-00-0005-iv Grundlagen der Informatik~S1|01 A2~20251022T081500~20251022T095500",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addAlarms(cal, rules)
+
+	got := alarmTriggers(t, cal)
+	want := map[string]string{
+		"01-10-0001-vl Analysis I":                "-PT15M",
+		"Klausur Analysis I":                      "-P1D,-PT1H",
+		"Tutorium Lineare Algebra":                "-PT1H30M",
+		"20-00-0005-iv Grundlagen der Informatik": "",
+	}
+	for summary, triggers := range want {
+		if strings.Join(got[summary], ",") != triggers {
+			t.Errorf("%s: triggers %v, want %s", summary, got[summary], triggers)
+		}
+	}
+	if !strings.Contains(cal.serialize(), "BEGIN:VALARM\r\nACTION:DISPLAY\r\nDESCRIPTION:Klausur Analysis I\r\nTRIGGER:-P1D\r\nEND:VALARM\r\nBEGIN:VALARM") {
+		t.Fatalf("unexpected VALARM:\n%s", cal.serialize())
+	}
+}
+
+func TestFormatICalDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                          "PT0S",
+		-15 * time.Minute:          "-PT15M",
+		-25 * time.Hour:            "-P1DT1H",
+		-48 * time.Hour:            "-P2D",
+		time.Hour + 30*time.Second: "PT1H30S",
+		// Fractions of a second are cut off, there is no "PT"
+		-500 * time.Millisecond: "PT0S",
+	}
+	for d, want := range tests {
+		if got := formatICalDuration(d); got != want {
+			t.Errorf("formatICalDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestHttpTucanAlarmQuery(t *testing.T) {
+	acc := newAccount("test", t.TempDir())
+	calendar := changesTestCalendar("1~Analysis I~S1|01 A1~20251020T081500~20251020T095500")
+	if err := os.WriteFile(acc.icalPath(), []byte(calendar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	httpTucan(acc)(rec, httptest.NewRequest("GET", "/tucan.ics?alarm=10m,1h", nil))
+	cal, err := parseICalendar(rec.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := alarmTriggers(t, cal)["Analysis I"]; strings.Join(got, ",") != "-PT10M,-PT1H" {
+		t.Fatalf("unexpected alarms %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	httpTucan(acc)(rec, httptest.NewRequest("GET", "/tucan.ics?alarm=soon", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid alarm, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	httpTucan(acc)(rec, httptest.NewRequest("GET", "/tucan.ics?alarm=500ms", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an alarm under a second, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	httpTucan(acc)(rec, httptest.NewRequest("GET", "/tucan.ics", nil))
+	if rec.Body.String() != calendar {
+		t.Fatal("expected the calendar unchanged without the alarm parameter")
+	}
+}
+
+func TestLoadConfigAlarms(t *testing.T) {
+	path := writeTestConfig(t, `
+alarms:
+  - kinds: [seminar]
+    before: [15m]
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+    alarms:
+      - courses: ["(Analysis"]
+      - keywords: [Klausur]
+        before: [-1h]
+      - before: [1.5s]
+`)
+	_, err := loadConfig(path, testEnv(nil))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		`alarms[0].kinds: unknown kind "seminar"`,
+		"accounts[0].alarms[0].courses:",
+		"accounts[0].alarms[0].before: at least one time is required",
+		"accounts[0].alarms[1].before: must not be negative",
+		"accounts[0].alarms[2].before: 1.5s is not a whole number of seconds",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
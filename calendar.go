@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const defaultProdID = "-//meisterlala//tucan-ical//EN"
+
+// calendarConfig describes the merged calendar to calendar apps. Empty
+// settings of an account fall back to the global ones, then to the defaults.
+type calendarConfig struct {
+	Name        string `yaml:"name,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	Timezone    string `yaml:"timezone,omitempty"`
+	// A CSS color name like "darkblue" for COLOR, or "#rrggbb" for Apple Calendar
+	Color string `yaml:"color,omitempty"`
+	// How often clients should poll the feed, defaults to the update interval
+	RefreshInterval duration `yaml:"refresh_interval,omitempty"`
+	ProdID          string   `yaml:"prodid,omitempty"`
+	// Write event times as Europe/Berlin local times (default) or in UTC
+	Times string `yaml:"times,omitempty"`
+	// Serve weekly sessions as one recurring event
+	Compact *bool `yaml:"compact,omitempty"`
+	// Add building names, addresses and coordinates to the rooms
+	Rooms *bool `yaml:"rooms,omitempty"`
+	// More buildings or corrections, in the format of rooms.yaml
+	RoomsFile string `yaml:"rooms_file,omitempty"`
+}
+
+const (
+	timesLocal = "local"
+	timesUTC   = "utc"
+)
+
+var (
+	colorNamePattern = regexp.MustCompile(`^[A-Za-z]+$`)
+	colorHexPattern  = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+)
+
+// Return cfg with its empty settings taken from fallback
+func (cfg calendarConfig) withDefaults(fallback calendarConfig) calendarConfig {
+	for _, field := range []struct{ value, fallback *string }{
+		{&cfg.Name, &fallback.Name},
+		{&cfg.Description, &fallback.Description},
+		{&cfg.Timezone, &fallback.Timezone},
+		{&cfg.Color, &fallback.Color},
+		{&cfg.ProdID, &fallback.ProdID},
+		{&cfg.Times, &fallback.Times},
+		{&cfg.RoomsFile, &fallback.RoomsFile},
+	} {
+		if *field.value == "" {
+			*field.value = *field.fallback
+		}
+	}
+	if cfg.RefreshInterval == 0 {
+		cfg.RefreshInterval = fallback.RefreshInterval
+	}
+	if cfg.Compact == nil {
+		cfg.Compact = fallback.Compact
+	}
+	if cfg.Rooms == nil {
+		cfg.Rooms = fallback.Rooms
+	}
+	return cfg
+}
+
+func (cfg calendarConfig) validate() error {
+	var errs []error
+	if cfg.Timezone != "" {
+		if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("timezone: unknown time zone %q", cfg.Timezone))
+		}
+	}
+	if cfg.Color != "" && !colorNamePattern.MatchString(cfg.Color) && !colorHexPattern.MatchString(cfg.Color) {
+		errs = append(errs, fmt.Errorf("color: %q must be a CSS color name or #rrggbb", cfg.Color))
+	}
+	if cfg.Times != "" && cfg.Times != timesLocal && cfg.Times != timesUTC {
+		errs = append(errs, fmt.Errorf("times: %q must be %s or %s", cfg.Times, timesLocal, timesUTC))
+	}
+	if cfg.RefreshInterval < 0 {
+		errs = append(errs, errors.New("refresh_interval: must not be negative"))
+	}
+	if cfg.RoomsFile != "" {
+		if _, err := loadRooms(cfg.RoomsFile); err != nil {
+			errs = append(errs, prefixErrors("rooms_file: ", err)...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Return the content lines describing the calendar, placed after BEGIN:VCALENDAR
+func (cfg calendarConfig) properties() []string {
+	prodID := cfg.ProdID
+	if prodID == "" {
+		prodID = defaultProdID
+	}
+	lines := []string{
+		"PRODID:" + prodID,
+		"VERSION:2.0",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+	}
+	if cfg.Name != "" {
+		lines = append(lines, "NAME:"+escapeText(cfg.Name), "X-WR-CALNAME:"+escapeText(cfg.Name))
+	}
+	if cfg.Description != "" {
+		lines = append(lines, "DESCRIPTION:"+escapeText(cfg.Description), "X-WR-CALDESC:"+escapeText(cfg.Description))
+	}
+	if cfg.Timezone != "" {
+		lines = append(lines, "X-WR-TIMEZONE:"+cfg.Timezone)
+	}
+	if refresh := time.Duration(cfg.RefreshInterval); refresh > 0 {
+		// Clients don't poll more often than every minute
+		value := formatICalDuration(max(refresh, time.Minute).Truncate(time.Minute))
+		lines = append(lines, "REFRESH-INTERVAL;VALUE=DURATION:"+value, "X-PUBLISHED-TTL:"+value)
+	}
+	switch {
+	case colorHexPattern.MatchString(cfg.Color):
+		lines = append(lines, "X-APPLE-CALENDAR-COLOR:"+cfg.Color)
+	case cfg.Color != "":
+		lines = append(lines, "COLOR:"+cfg.Color)
+	}
+	return lines
+}
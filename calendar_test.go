@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// A monthly export as TUCaN sends it, with its own calendar properties
+func tucanMonthExport(uid, summary, start string) string {
+	return strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"PRODID:-//Datenlotsen Informationssysteme GmbH//CampusNet//DE",
+		"VERSION:2.0",
+		"METHOD:PUBLISH",
+		"BEGIN:VEVENT",
+		"UID:" + uid,
+		"SUMMARY:" + summary,
+		"DESCRIPTION:Raum S1|01 A1\\, Hörsaal mit einer sehr langen Beschreibung die übe",
+		" r mehrere Zeilen gefaltet ist",
+		"DTSTART;TZID=Europe/Berlin:" + start,
+		"DTEND;TZID=Europe/Berlin:" + start,
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+}
+
+func TestMergeIcsHeader(t *testing.T) {
+	cfg := calendarConfig{
+		Name:            "TUCaN alice",
+		Description:     "Stundenplan, Wintersemester",
+		Timezone:        "Europe/Berlin",
+		Color:           "darkblue",
+		RefreshInterval: duration(2 * time.Hour),
+	}
+	merged := mergeIcs([]string{
+		tucanMonthExport("1", "Analysis I", "20251020T081500"),
+		tucanMonthExport("2", "Lineare Algebra", "20251103T081500"),
+	}, cfg.properties())
+
+	cal, err := parseICalendar(merged)
+	if err != nil {
+		t.Fatalf("merged calendar doesn't parse: %v\n%s", err, merged)
+	}
+	if count, problems := validateICalendar(cal); count != 2 || len(problems) > 0 {
+		t.Fatalf("expected 2 valid events, got %d: %v", count, problems)
+	}
+	want := []string{
+		"PRODID:" + defaultProdID,
+		"VERSION:2.0",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"NAME:TUCaN alice",
+		"X-WR-CALNAME:TUCaN alice",
+		`DESCRIPTION:Stundenplan\, Wintersemester`,
+		`X-WR-CALDESC:Stundenplan\, Wintersemester`,
+		"X-WR-TIMEZONE:Europe/Berlin",
+		"REFRESH-INTERVAL;VALUE=DURATION:PT2H",
+		"X-PUBLISHED-TTL:PT2H",
+		"COLOR:darkblue",
+	}
+	var got []string
+	for _, prop := range cal.properties {
+		got = append(got, prop.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected calendar properties:\n%s", strings.Join(got, "\n"))
+	}
+	// Folded lines of the events survive
+	if desc := cal.children("VEVENT")[0].value("DESCRIPTION"); !strings.HasSuffix(desc, "über mehrere Zeilen gefaltet ist") {
+		t.Fatalf("unexpected description %q", desc)
+	}
+}
+
+func TestCalendarPropertiesColor(t *testing.T) {
+	props := calendarConfig{Color: "#1E90FF"}.properties()
+	if !strings.Contains(strings.Join(props, "\n"), "X-APPLE-CALENDAR-COLOR:#1E90FF") {
+		t.Fatalf("expected an Apple calendar color, got %v", props)
+	}
+}
+
+func TestLoadConfigCalendar(t *testing.T) {
+	path := writeTestConfig(t, `
+calendar:
+  description: Stundenplan
+  color: darkblue
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+    update_interval: 30m
+    feed_tokens: [alice-token-0123456789]
+    calendar:
+      color: "#1e90ff"
+  - name: bob
+    username: cd34efgh
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0002
+    feed_tokens: [bob-token-0123456789]
+    calendar:
+      name: Bobs Stundenplan
+      refresh_interval: 6h
+`)
+	cfg, err := loadConfig(path, testEnv(nil))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	accounts, err := cfg.buildAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := accounts[0].calendar, accounts[1].calendar
+	if alice.Name != "TUCaN alice" || alice.Description != "Stundenplan" || alice.Color != "#1e90ff" || alice.RefreshInterval != duration(30*time.Minute) {
+		t.Errorf("unexpected calendar of alice %+v", alice)
+	}
+	if bob.Name != "Bobs Stundenplan" || bob.Color != "darkblue" || bob.Timezone != "Europe/Berlin" || bob.RefreshInterval != duration(6*time.Hour) {
+		t.Errorf("unexpected calendar of bob %+v", bob)
+	}
+
+	path = writeTestConfig(t, `
+calendar:
+  timezone: Europe/Darmstadt
+  color: "rgb(0,0,255)"
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+`)
+	_, err = loadConfig(path, testEnv(map[string]string{"CALENDAR_NAME": "Uni"}))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{`calendar.timezone: unknown time zone "Europe/Darmstadt"`, "calendar.color:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	changesFile    = "changes.json"
+	maxChangeSets  = 200
+	changeTimeZone = "Europe/Berlin"
+)
+
+// Types of changes between two updates
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeMoved   = "moved"
+	changeRoom    = "room"
+	changeTitle   = "title"
+)
+
+// calendarEvent is the part of a VEVENT that is compared between updates,
+// with the course information for reminders and the API
+type calendarEvent struct {
+	UID      string
+	Summary  string
+	Location string
+	Start    time.Time
+	End      time.Time
+	Course   courseInfo
+}
+
+// eventChange is one difference between two updates. For moved, room and
+// title changes the Old fields hold the previous values.
+type eventChange struct {
+	Type        string    `json:"type"`
+	UID         string    `json:"uid,omitempty"`
+	Summary     string    `json:"summary"`
+	Location    string    `json:"location,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end,omitzero"`
+	OldSummary  string    `json:"old_summary,omitempty"`
+	OldLocation string    `json:"old_location,omitempty"`
+	OldStart    time.Time `json:"old_start,omitzero"`
+	OldEnd      time.Time `json:"old_end,omitzero"`
+}
+
+// changeSet holds every change found by one update
+type changeSet struct {
+	Account string        `json:"account"`
+	Time    time.Time     `json:"time"`
+	Changes []eventChange `json:"changes"`
+}
+
+// changeLog persists the change sets of an account in its data directory
+type changeLog struct {
+	mu     sync.Mutex
+	path   string
+	loaded bool
+	sets   []changeSet
+}
+
+func newChangeLog(path string) *changeLog {
+	return &changeLog{path: path}
+}
+
+// Extract the events of a calendar. Times without a TZID are taken as
+// Europe/Berlin, like TUCaN means them.
+func calendarEvents(cal *icalComponent) []calendarEvent {
+	var events []calendarEvent
+	for _, vevent := range cal.children("VEVENT") {
+		start, err := parseICalTime(vevent.property("DTSTART"), berlin)
+		if err != nil {
+			continue
+		}
+		end, _ := parseICalTime(vevent.property("DTEND"), berlin)
+		events = append(events, calendarEvent{
+			UID:      vevent.value("UID"),
+			Summary:  unescapeText(vevent.value("SUMMARY")),
+			Location: unescapeText(vevent.value("LOCATION")),
+			Start:    start,
+			End:      end,
+			Course:   eventCourseInfo(vevent),
+		})
+	}
+	return events
+}
+
+// Compare two event sets. Events are matched by UID first. Events left over
+// are matched by title and start time, then by room and start time, so a room
+// or title change is found even if TUCaN assigned a new UID. Removed events
+// that already ended before now are left out, they only fell out of the
+// exported months.
+func diffEvents(before, after []calendarEvent, now time.Time) []eventChange {
+	var changes []eventChange
+	beforeByUID := make(map[string]int)
+	for i, event := range before {
+		if event.UID != "" {
+			beforeByUID[event.UID] = i
+		}
+	}
+	matchedBefore := make([]bool, len(before))
+	matchedAfter := make([]bool, len(after))
+
+	for j, event := range after {
+		if i, ok := beforeByUID[event.UID]; ok && event.UID != "" && !matchedBefore[i] {
+			matchedBefore[i], matchedAfter[j] = true, true
+			changes = append(changes, compareEvents(before[i], event)...)
+		}
+	}
+
+	// Fall back to events sharing the start time and either title or room
+	sameKey := func(key func(calendarEvent) string) {
+		for j, event := range after {
+			if matchedAfter[j] {
+				continue
+			}
+			for i := range before {
+				if !matchedBefore[i] && before[i].Start.Equal(event.Start) && key(before[i]) == key(event) {
+					matchedBefore[i], matchedAfter[j] = true, true
+					changes = append(changes, compareEvents(before[i], event)...)
+					break
+				}
+			}
+		}
+	}
+	sameKey(func(e calendarEvent) string { return e.Summary })
+	sameKey(func(e calendarEvent) string { return e.Location })
+
+	for j, event := range after {
+		if !matchedAfter[j] {
+			changes = append(changes, newEventChange(changeAdded, event))
+		}
+	}
+	for i, event := range before {
+		if !matchedBefore[i] && !eventEnd(event).Before(now) {
+			changes = append(changes, newEventChange(changeRemoved, event))
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Start.Before(changes[j].Start)
+	})
+	return changes
+}
+
+func compareEvents(before, after calendarEvent) []eventChange {
+	var changes []eventChange
+	if !before.Start.Equal(after.Start) || !before.End.Equal(after.End) {
+		change := newEventChange(changeMoved, after)
+		change.OldStart, change.OldEnd = before.Start, before.End
+		changes = append(changes, change)
+	}
+	if before.Location != after.Location {
+		change := newEventChange(changeRoom, after)
+		change.OldLocation = before.Location
+		changes = append(changes, change)
+	}
+	if before.Summary != after.Summary {
+		change := newEventChange(changeTitle, after)
+		change.OldSummary = before.Summary
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func newEventChange(kind string, event calendarEvent) eventChange {
+	return eventChange{
+		Type:     kind,
+		UID:      event.UID,
+		Summary:  event.Summary,
+		Location: event.Location,
+		Start:    event.Start,
+		End:      event.End,
+	}
+}
+
+func eventEnd(event calendarEvent) time.Time {
+	if event.End.IsZero() {
+		return event.Start
+	}
+	return event.End
+}
+
+// Compare the previous and the new merged calendar and record the changes.
+// Without a previous calendar there is nothing to compare against, the new
+// one becomes the baseline. Events can only be removed from months in
+// fetched ("2006-01"), the others may just have failed to export after a
+// restart. It returns the recorded set, or nil.
+func (l *changeLog) record(account, previous, current string, fetched map[string]bool, now time.Time) (*changeSet, error) {
+	if previous == "" {
+		return nil, nil
+	}
+	oldCal, err := parseICalendar(previous)
+	if err != nil {
+		return nil, fmt.Errorf("parse previous calendar: %w", err)
+	}
+	newCal, err := parseICalendar(current)
+	if err != nil {
+		return nil, fmt.Errorf("parse new calendar: %w", err)
+	}
+
+	var changes []eventChange
+	for _, change := range diffEvents(calendarEvents(oldCal), calendarEvents(newCal), now) {
+		if change.Type == changeRemoved && !fetched[change.Start.In(berlin).Format("2006-01")] {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	set := changeSet{Account: account, Time: now, Changes: changes}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	l.sets = append(l.sets, set)
+	if len(l.sets) > maxChangeSets {
+		l.sets = l.sets[len(l.sets)-maxChangeSets:]
+	}
+	return &set, l.save()
+}
+
+// Return the change sets recorded after since, oldest first
+func (l *changeLog) since(since time.Time) ([]changeSet, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	var sets []changeSet
+	for _, set := range l.sets {
+		if set.Time.After(since) {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+func (l *changeLog) load() error {
+	if l.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		l.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &l.sets); err != nil {
+		return fmt.Errorf("%s: %w", l.path, err)
+	}
+	l.loaded = true
+	return nil
+}
+
+func (l *changeLog) save() error {
+	data, err := json.MarshalIndent(l.sets, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, data)
+}
+
+// Write to a temporary file first so a crash doesn't leave a truncated file
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Write the change sets newest first as a changelog, in Markdown or plain text
+func writeChangelog(w io.Writer, sets []changeSet, markdown bool) {
+	if len(sets) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		return
+	}
+
+	for i := len(sets) - 1; i >= 0; i-- {
+		set := sets[i]
+		heading := fmt.Sprintf("%s (%s)", set.Time.In(berlin).Format("2006-01-02 15:04"), set.Account)
+		if markdown {
+			fmt.Fprintf(w, "## %s\n\n", heading)
+		} else {
+			fmt.Fprintf(w, "%s\n%s\n", heading, strings.Repeat("=", len(heading)))
+		}
+		for _, change := range set.Changes {
+			label, text := describeChange(change, berlin)
+			if markdown {
+				fmt.Fprintf(w, "- **%s** %s\n", label, text)
+			} else {
+				fmt.Fprintf(w, "- %s: %s\n", label, text)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func describeChange(change eventChange, loc *time.Location) (string, string) {
+	when := formatEventTime(change.Start, change.End, loc)
+	switch change.Type {
+	case changeAdded:
+		return "Added", withLocation(fmt.Sprintf("%s, %s", change.Summary, when), change.Location)
+	case changeRemoved:
+		return "Removed", withLocation(fmt.Sprintf("%s, %s", change.Summary, when), change.Location)
+	case changeMoved:
+		return "Moved", fmt.Sprintf("%s from %s to %s", change.Summary, formatEventTime(change.OldStart, change.OldEnd, loc), when)
+	case changeRoom:
+		return "Room changed", fmt.Sprintf("%s, %s: %s → %s", change.Summary, when, orNone(change.OldLocation), orNone(change.Location))
+	case changeTitle:
+		return "Title changed", fmt.Sprintf("%s: %q → %q", when, change.OldSummary, change.Summary)
+	}
+	return change.Type, fmt.Sprintf("%s, %s", change.Summary, when)
+}
+
+func formatEventTime(start, end time.Time, loc *time.Location) string {
+	text := start.In(loc).Format("Mon 02.01.2006 15:04")
+	if !end.IsZero() {
+		text += "–" + end.In(loc).Format("15:04")
+	}
+	return text
+}
+
+func withLocation(text, location string) string {
+	if location == "" {
+		return text
+	}
+	return text + " in " + location
+}
+
+func orNone(location string) string {
+	if location == "" {
+		return "(none)"
+	}
+	return location
+}
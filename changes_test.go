@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Build a calendar from events given as "UID~SUMMARY~LOCATION~DTSTART~DTEND"
+func changesTestCalendar(events ...string) string {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\n")
+	for _, event := range events {
+		fields := strings.Split(event, "~")
+		b.WriteString("BEGIN:VEVENT\r\n")
+		if fields[0] != "" {
+			b.WriteString("UID:" + fields[0] + "\r\n")
+		}
+		fmt.Fprintf(&b, "SUMMARY:%s\r\nLOCATION:%s\r\n", fields[1], fields[2])
+		fmt.Fprintf(&b, "DTSTART;TZID=Europe/Berlin:%s\r\nDTEND;TZID=Europe/Berlin:%s\r\n", fields[3], fields[4])
+		b.WriteString("END:VEVENT\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+	return b.String()
+}
+
+var changesTestMonths = map[string]bool{"2025-10": true, "2025-11": true}
+
+func TestChangeLogRecordsDiff(t *testing.T) {
+	previous := changesTestCalendar(
+		"1~Analysis I~S1|01 A1~20251020T081500~20251020T095500",
+		"2~Algorithmen und Datenstrukturen~S2|02 C110~20251021T081500~20251021T095500",
+		"3~Lineare Algebra~S1|03 23~20251022T081500~20251022T095500",
+		"4~Tutorium~S2|02 C205~20251023T131500~20251023T145500",
+		// Past events that only fall out of the export aren't removals
+		"5~Einführung~S1|01 A1~20250701T081500~20250701T095500",
+	)
+	current := changesTestCalendar(
+		"1~Analysis I~S1|01 A1~20251020T100000~20251020T114000",
+		"2~Algorithmen und Datenstrukturen~S3|11 08~20251021T081500~20251021T095500",
+		"3~Lineare Algebra für Informatik~S1|03 23~20251022T081500~20251022T095500",
+		"6~Klausur Analysis I~S1|01 A1~20251110T090000~20251110T110000",
+	)
+
+	log := newChangeLog(filepath.Join(t.TempDir(), "alice", changesFile))
+	now := time.Date(2025, 10, 14, 8, 0, 0, 0, time.UTC)
+	set, err := log.record("alice", previous, current, changesTestMonths, now)
+	if err != nil {
+		t.Fatalf("record failed: %v", err)
+	}
+	if set == nil {
+		t.Fatal("expected changes")
+	}
+
+	var got []string
+	for _, change := range set.Changes {
+		got = append(got, change.Type+" "+change.UID)
+	}
+	want := []string{"moved 1", "room 2", "title 3", "removed 4", "added 6"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+	room := set.Changes[1]
+	if room.OldLocation != "S2|02 C110" || room.Location != "S3|11 08" {
+		t.Fatalf("unexpected room change %+v", room)
+	}
+
+	// The log survives a restart
+	reloaded := newChangeLog(log.path)
+	sets, err := reloaded.since(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 || len(sets[0].Changes) != 5 || !sets[0].Time.Equal(now) {
+		t.Fatalf("unexpected reloaded sets %+v", sets)
+	}
+	if sets, _ := reloaded.since(now); len(sets) != 0 {
+		t.Fatalf("expected no sets after %v, got %d", now, len(sets))
+	}
+}
+
+func TestChangeLogMatchesChangedUIDs(t *testing.T) {
+	previous := changesTestCalendar(
+		"a~Analysis I~S1|01 A1~20251020T081500~20251020T095500",
+		"b~Lineare Algebra~S1|03 23~20251022T081500~20251022T095500",
+	)
+	// TUCaN assigned new UIDs, the events are matched by start time and title or room
+	current := changesTestCalendar(
+		"x~Analysis I~S2|02 C110~20251020T081500~20251020T095500",
+		"y~Lineare Algebra II~S1|03 23~20251022T081500~20251022T095500",
+	)
+
+	set, err := newChangeLog(filepath.Join(t.TempDir(), changesFile)).record("alice", previous, current, changesTestMonths, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set == nil || len(set.Changes) != 2 || set.Changes[0].Type != changeRoom || set.Changes[1].Type != changeTitle {
+		t.Fatalf("expected a room and a title change, got %+v", set)
+	}
+}
+
+func TestChangeLogIgnoresUnfetchedMonths(t *testing.T) {
+	previous := changesTestCalendar(
+		"1~Analysis I~S1|01 A1~20251020T081500~20251020T095500",
+		"2~Analysis I~S1|01 A1~20251201T081500~20251201T095500",
+	)
+	current := changesTestCalendar(
+		"1~Analysis I~S1|01 A1~20251020T081500~20251020T095500",
+	)
+
+	log := newChangeLog(filepath.Join(t.TempDir(), changesFile))
+	set, err := log.record("alice", previous, current, changesTestMonths, time.Time{})
+	if err != nil || set != nil {
+		t.Fatalf("expected no changes for a month that wasn't fetched, got %+v, %v", set, err)
+	}
+
+	// Without a previous calendar the first one is the baseline
+	set, err = log.record("alice", "", current, changesTestMonths, time.Time{})
+	if err != nil || set != nil {
+		t.Fatalf("expected no changes without a previous calendar, got %+v, %v", set, err)
+	}
+}
+
+func TestWriteChangelog(t *testing.T) {
+	start := time.Date(2025, 10, 20, 6, 15, 0, 0, time.UTC)
+	sets := []changeSet{
+		{Account: "alice", Time: time.Date(2025, 10, 13, 8, 0, 0, 0, time.UTC), Changes: []eventChange{
+			{Type: changeAdded, Summary: "Analysis I", Location: "S1|01 A1", Start: start, End: start.Add(100 * time.Minute)},
+		}},
+		{Account: "alice", Time: time.Date(2025, 10, 14, 8, 0, 0, 0, time.UTC), Changes: []eventChange{
+			{Type: changeRoom, Summary: "Analysis I", Location: "S2|02 C110", OldLocation: "S1|01 A1", Start: start, End: start.Add(100 * time.Minute)},
+		}},
+	}
+
+	var markdown strings.Builder
+	writeChangelog(&markdown, sets, true)
+	want := "## 2025-10-14 10:00 (alice)\n\n" +
+		"- **Room changed** Analysis I, Mon 20.10.2025 08:15–09:55: S1|01 A1 → S2|02 C110\n\n" +
+		"## 2025-10-13 10:00 (alice)\n\n" +
+		"- **Added** Analysis I, Mon 20.10.2025 08:15–09:55 in S1|01 A1\n\n"
+	if markdown.String() != want {
+		t.Fatalf("unexpected Markdown changelog:\n%s\nwant:\n%s", markdown.String(), want)
+	}
+
+	var text strings.Builder
+	writeChangelog(&text, sets[:1], false)
+	if !strings.HasPrefix(text.String(), "2025-10-13 10:00 (alice)\n========================\n- Added: Analysis I") {
+		t.Fatalf("unexpected plain text changelog:\n%s", text.String())
+	}
+}
+
+func TestHttpChanges(t *testing.T) {
+	alice := newAccount("alice", t.TempDir())
+	alice.auth.tokens = newSecretList([]string{"alice-token-0123456789"}, "")
+	alice.apiTokens = newSecretList([]string{"alice-api-0123456789"}, "")
+	bob := newAccount("bob", t.TempDir())
+	bob.apiTokens = newSecretList([]string{"bob-api-0123456789"}, "")
+	handler := httpChanges([]*account{alice, bob}, newSecretList([]string{"admin-token-0123456789"}, ""))
+
+	previous := changesTestCalendar("1~Analysis I~S1|01 A1~20251020T081500~20251020T095500")
+	current := changesTestCalendar("1~Analysis I~S2|02 C110~20251020T081500~20251020T095500")
+	now := time.Date(2025, 10, 14, 8, 0, 0, 0, time.UTC)
+	for _, acc := range []*account{alice, bob} {
+		if _, err := acc.changes.record(acc.name, previous, current, changesTestMonths, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(token, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/changes"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := get("", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+
+	if rec := get("alice-token-0123456789", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a feed token, got %d", rec.Code)
+	}
+	rec := get("alice-api-0123456789", "")
+	var sets []changeSet
+	if err := json.Unmarshal(rec.Body.Bytes(), &sets); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body, err)
+	}
+	if len(sets) != 1 || sets[0].Account != "alice" || sets[0].Changes[0].Type != changeRoom {
+		t.Fatalf("expected only alice's changes, got %+v", sets)
+	}
+
+	if rec := get("admin-token-0123456789", "?since=2025-10-14T08:00:00Z"); strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Fatalf("expected no changes after since, got %s", rec.Body)
+	}
+	if rec := get("admin-token-0123456789", "?since=yesterday"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid since, got %d", rec.Code)
+	}
+
+	rec = get("admin-token-0123456789", "?account=bob&format=markdown")
+	if rec.Header().Get("Content-Type") != "text/markdown; charset=utf-8" || !strings.Contains(rec.Body.String(), "## 2025-10-14 10:00 (bob)") {
+		t.Fatalf("unexpected Markdown response %q", rec.Body)
+	}
+}
+
+func TestApplyUpdateToOtherFile(t *testing.T) {
+	dir := t.TempDir()
+	acc := newAccount("alice", dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	acc.notifiers = []notifier{newWebhookNotifier(webhookConfig{URL: server.URL}, acc.deliveries)}
+
+	previous := changesTestCalendar("1~Analysis I~S1|01 A1~20251020T081500~20251020T095500")
+	current := changesTestCalendar("1~Analysis I~S2|02 C110~20251020T081500~20251020T095500")
+	fetched := func(ics string) *tucanData {
+		return &tucanData{months: map[string]string{"2025-10": ics}, sources: map[string]string{"2025-10": "export"}}
+	}
+	data := &tucanData{months: map[string]string{}, sources: map[string]string{}}
+	if err := applyUpdate(acc, data, fetched(previous), acc.icalPath()); err != nil {
+		t.Fatal(err)
+	}
+	original, err := os.ReadFile(acc.originalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A one-shot fetch to another file doesn't touch the account's state
+	out := filepath.Join(t.TempDir(), "preview.ics")
+	if err := applyUpdate(acc, data, fetched(current), out); err != nil {
+		t.Fatal(err)
+	}
+	acc.notifying.Wait()
+	if body, err := os.ReadFile(out); err != nil || !strings.Contains(string(body), "S2|02 C110") {
+		t.Fatalf("expected the calendar in %s, got %q, %v", out, body, err)
+	}
+	if body, _ := os.ReadFile(acc.originalPath()); string(body) != string(original) {
+		t.Fatal("expected the original calendar to be left alone")
+	}
+	for _, name := range []string{changesFile, deliveriesFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected no %s, got %v", name, err)
+		}
+	}
+
+	// The stored calendar still sees the change
+	if err := applyUpdate(acc, data, fetched(current), acc.icalPath()); err != nil {
+		t.Fatal(err)
+	}
+	acc.notifying.Wait()
+	sets, err := acc.changes.since(time.Time{})
+	if err != nil || len(sets) != 1 {
+		t.Fatalf("expected one change set, got %+v, %v", sets, err)
+	}
+	if deliveries, err := acc.deliveries.list(); err != nil || len(deliveries) != 1 {
+		t.Fatalf("expected one delivery, got %+v, %v", deliveries, err)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// command is a subcommand of the binary, e.g. "tucan-ical fetch --once"
+type command struct {
+	name        string
+	args        string
+	description string
+	run         func(configPath string, args []string) error
+}
+
+var commands []command
+
+// Registered in init because the commands refer back to the list for their usage
+func init() {
+	commands = []command{
+		{"serve", "", "start the web server and update every account (default)", runServe},
+		{"fetch", "[--once] [--out file.ics] [--no-rewrite] [--account name]", "fetch the calendar without starting the web server", runFetch},
+		{"login-check", "[--account name]", "log in and report which stage succeeded", runLoginCheck},
+		{"totp", "[--account name]", "print the current TOTP codes", runTOTP},
+		{"tokens", "[--account name]", "list the tokens offered on the token selection page", runTokens},
+		{"validate", "file.ics", "check an iCalendar file for problems", runValidate},
+		{"config", "", "print the effective config with secrets masked", runPrintConfig},
+		{"schedule", "[-n 10] [--account name]", "print the next update times", runSchedule},
+		{"changes", "[--markdown] [--since 2006-01-02] [--account name]", "print the changelog of the calendar", runChanges},
+		{"rewrite", "[--account name] [file.ics]", "preview what the rewrite rules change", runRewrite},
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-config file] <command> [arguments]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-12s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintln(out, "\nGlobal flags:")
+	flag.PrintDefaults()
+}
+
+func runCommand(name, configPath string, args []string) error {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(configPath, args)
+		}
+	}
+	usage()
+	return fmt.Errorf("unknown command %q", name)
+}
+
+func newCommandFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n\n%s\n", os.Args[0], cmd.name, cmd.args, cmd.description)
+			}
+		}
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Load the config and pick the account named by --account, or the only one
+func loadCommandAccount(configPath, name string, getenv func(string) string) (*account, error) {
+	cfg, err := loadConfig(configPath, getenv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return commandAccount(cfg, name)
+}
+
+// Build the accounts of a validated config and pick the one named by
+// --account, or the only one
+func commandAccount(cfg config, name string) (*account, error) {
+	debugLogin = cfg.DebugLogin
+
+	accounts, err := cfg.buildAccounts()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	if name == "" {
+		if len(accounts) > 1 {
+			return nil, errors.New("there is more than one account, choose one with --account")
+		}
+		return accounts[0], nil
+	}
+	for _, acc := range accounts {
+		if acc.name == name {
+			return acc, nil
+		}
+	}
+	return nil, fmt.Errorf("no account named %q", name)
+}
+
+func runServe(configPath string, args []string) error {
+	newCommandFlags("serve").Parse(args)
+
+	// Read the config file and let the environment variables override it
+	cfg, err := loadConfig(configPath, os.Getenv)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	debugLogin = cfg.DebugLogin
+
+	accounts, err := cfg.buildAccounts()
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	log.Printf("Managing %d account(s)", len(accounts))
+
+	go runWebServer(cfg, accounts)
+
+	// SIGHUP requests an immediate update of every account
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			for _, acc := range accounts {
+				if job, err := acc.refresh.request(acc.name, "SIGHUP"); err != nil {
+					acc.log.Printf("Refresh on SIGHUP skipped: %v", err)
+				} else {
+					acc.log.Printf("Refresh %s queued on SIGHUP", job.ID)
+				}
+			}
+		}
+	}()
+
+	// Fetch the iCalendar data, each account on its own schedule
+	var updaters sync.WaitGroup
+	for _, acc := range accounts {
+		updaters.Add(1)
+		go func() {
+			defer updaters.Done()
+			startCalendarUpdater(acc)
+		}()
+		if acc.reminders != nil {
+			go runReminders(acc)
+		}
+	}
+
+	updaters.Wait()
+	return errors.New("all calendar updaters stopped")
+}
+
+func runFetch(configPath string, args []string) error {
+	flags := newCommandFlags("fetch")
+	once := flags.Bool("once", false, "fetch a single time instead of every update interval")
+	out := flags.String("out", "-", "file to write the merged calendar to, - for stdout")
+	noRewrite := flags.Bool("no-rewrite", false, "leave the events as TUCaN exports them, e.g. to preview rewrite rules on them")
+	accountName := flags.String("account", "", "account to fetch, required with more than one account")
+	flags.Parse(args)
+
+	acc, err := loadCommandAccount(configPath, *accountName, os.Getenv)
+	if err != nil {
+		return err
+	}
+	if *noRewrite {
+		acc.rewrites = nil
+	}
+	// Fetching by hand is for trying things out, the grades and the
+	// notifications are left to the running server
+	acc.notifiers = nil
+	acc.grades = nil
+	return runCalendarUpdater(acc, *out, *once)
+}
+
+func runLoginCheck(configPath string, args []string) error {
+	flags := newCommandFlags("login-check")
+	accountName := flags.String("account", "", "account to check, required with more than one account")
+	flags.Parse(args)
+
+	acc, err := loadCommandAccount(configPath, *accountName, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	trace := &loginTrace{
+		stage: func(name string) {
+			fmt.Printf("ok    %s\n", name)
+		},
+	}
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	if _, err := loginWithTrace(client, acc.username.get(), acc.password.get(), acc.totpSeed.get(), acc.totpID.get(), trace); err != nil {
+		fmt.Printf("FAIL  %v\n", err)
+		return errors.New("login failed")
+	}
+	fmt.Println("Login succeeded")
+	return nil
+}
+
+func runTOTP(configPath string, args []string) error {
+	flags := newCommandFlags("totp")
+	accountName := flags.String("account", "", "account whose TOTP seed to use, required with more than one account")
+	flags.Parse(args)
+
+	acc, err := loadCommandAccount(configPath, *accountName, os.Getenv)
+	if err != nil {
+		return err
+	}
+	params, err := parseTOTP(acc.totpSeed.get())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	step := int64(params.period / time.Second)
+	remaining := step - now.Unix()%step
+	fmt.Printf("previous %s\n", params.code(now.Add(-params.period)))
+	fmt.Printf("current  %s (valid for %ds)\n", params.code(now), remaining)
+	fmt.Printf("next     %s\n", params.code(now.Add(params.period)))
+	return nil
+}
+
+func runTokens(configPath string, args []string) error {
+	flags := newCommandFlags("tokens")
+	accountName := flags.String("account", "", "account to log in with, required with more than one account")
+	flags.Parse(args)
+
+	// The token ID is what we want to find out, so it doesn't have to be configured yet
+	cfg, err := readConfig(configPath, os.Getenv)
+	if err == nil {
+		cfg.optionalTOTPID = true
+		err = cfg.validate()
+	}
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	acc, err := commandAccount(cfg, *accountName)
+	if err != nil {
+		return err
+	}
+
+	var found map[string]string
+	trace := &loginTrace{
+		tokens: func(tokens map[string]string) {
+			found = tokens
+		},
+		stopAtTokens: true,
+	}
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	_, loginErr := loginWithTrace(client, acc.username.get(), acc.password.get(), acc.totpSeed.get(), acc.totpID.get(), trace)
+	if found == nil {
+		if loginErr != nil {
+			return fmt.Errorf("login failed before the token selection page: %w", loginErr)
+		}
+		fmt.Println("No token selection page was shown")
+		return nil
+	}
+
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Printf("%s\t%s\n", id, found[id])
+	}
+	return nil
+}
+
+func runValidate(configPath string, args []string) error {
+	flags := newCommandFlags("validate")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one file")
+	}
+
+	path := flags.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cal, err := parseICalendar(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	events, problems := validateICalendar(cal)
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", path, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: found %d problem(s) in %d events", path, len(problems), events)
+	}
+	fmt.Printf("%s: OK, %d events\n", path, events)
+	return nil
+}
+
+func runPrintConfig(configPath string, args []string) error {
+	newCommandFlags("config").Parse(args)
+
+	cfg, err := loadConfig(configPath, os.Getenv)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg.print(os.Stdout)
+}
+
+func runSchedule(configPath string, args []string) error {
+	flags := newCommandFlags("schedule")
+	count := flags.Int("n", 10, "number of update times to print")
+	accountName := flags.String("account", "", "account whose schedule to print, required with more than one account")
+	flags.Parse(args)
+
+	acc, err := loadCommandAccount(configPath, *accountName, os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	// Assume every update starts on time, the jitter is left out
+	next := time.Now()
+	for range *count {
+		next = acc.schedule.next(next)
+		local := next.In(acc.schedule.loc)
+		fmt.Printf("%s  (every %s)\n", local.Format("Mon 2006-01-02 15:04 MST"), acc.schedule.intervalAt(next))
+	}
+	return nil
+}
+
+func runChanges(configPath string, args []string) error {
+	flags := newCommandFlags("changes")
+	markdown := flags.Bool("markdown", false, "print Markdown instead of plain text")
+	sinceDate := flags.String("since", "", "only print changes after this date (YYYY-MM-DD)")
+	accountName := flags.String("account", "", "account whose changes to print, required with more than one account")
+	flags.Parse(args)
+
+	var since time.Time
+	if *sinceDate != "" {
+		var err error
+		if since, err = time.ParseInLocation("2006-01-02", *sinceDate, time.Local); err != nil {
+			return fmt.Errorf("invalid --since date %q, use YYYY-MM-DD", *sinceDate)
+		}
+	}
+
+	acc, err := loadCommandAccount(configPath, *accountName, os.Getenv)
+	if err != nil {
+		return err
+	}
+	sets, err := acc.changes.since(since)
+	if err != nil {
+		return err
+	}
+	writeChangelog(os.Stdout, sets, *markdown)
+	return nil
+}
+
+func runRewrite(configPath string, args []string) error {
+	flags := newCommandFlags("rewrite")
+	accountName := flags.String("account", "", "account whose rules to use, required with more than one account")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errors.New("expected at most one file")
+	}
+
+	acc, err := loadCommandAccount(configPath, *accountName, os.Getenv)
+	if err != nil {
+		return err
+	}
+	if len(acc.rewrites) == 0 {
+		return errors.New("no rewrite rules are configured")
+	}
+	// The calendar is stored before the rules too, a file fetched with
+	// --no-rewrite works as well
+	path := acc.originalPath()
+	if flags.NArg() == 1 {
+		path = flags.Arg(0)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cal, err := parseICalendar(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	total := len(cal.children("VEVENT"))
+	annotateCourses(cal)
+	edits := rewriteEvents(cal, acc.rewrites)
+	writeRewriteEdits(os.Stdout, edits)
+
+	changed, dropped := make(map[string]bool), 0
+	for _, edit := range edits {
+		changed[edit.UID] = true
+		if edit.Dropped {
+			dropped++
+		}
+	}
+	fmt.Printf("%d of %d events changed, %d dropped\n", len(changed), total, dropped)
+	return nil
+}
+
+// Print the edits grouped by event, e.g.
+//
+//	20-00-0005-iv Grundlagen der Informatik I
+//	  SUMMARY: "20-00-0005-iv Grundlagen der Informatik I" → "GdI I"
+func writeRewriteEdits(w io.Writer, edits []rewriteEdit) {
+	for i, edit := range edits {
+		if i == 0 || edits[i-1].UID != edit.UID {
+			fmt.Fprintf(w, "%s (%s)\n", edit.Summary, edit.UID)
+		}
+		if edit.Dropped {
+			fmt.Fprintln(w, "  dropped")
+		} else {
+			fmt.Fprintf(w, "  %s: %q → %q\n", edit.Property, edit.Old, edit.New)
+		}
+	}
+}
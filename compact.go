@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Only sessions held in at least this many weeks become a recurrence
+const compactMinWeeks = 3
+
+// compactItem is a single event that may become part of a recurrence
+type compactItem struct {
+	event      *icalComponent
+	start, end time.Time
+}
+
+// compactSeries is a weekly recurrence built from the items of one slot
+type compactSeries struct {
+	items     []*compactItem
+	first     time.Time
+	weeks     int
+	missing   map[int]bool
+	overrides []*icalComponent
+	rdates    []time.Time
+}
+
+// Collapse events with the same title, location, weekday, time and duration
+// into one weekly RRULE. Weeks without the session become EXDATEs, a session
+// moved to another time in such a week becomes an override with RECURRENCE-ID
+// and an extra session at another time becomes an RDATE. Recurrences are
+// written in Europe/Berlin local time so they follow the DST switches.
+func compactEvents(cal *icalComponent) {
+	bySummary := make(map[string][]*compactItem)
+	var summaries []string
+	for _, event := range cal.children("VEVENT") {
+		if event.property("RRULE") != nil || event.property("RECURRENCE-ID") != nil {
+			continue
+		}
+		dtstart := event.property("DTSTART")
+		if dtstart == nil || dtstart.params["VALUE"] == "DATE" {
+			continue
+		}
+		start, err := parseICalTime(dtstart, berlin)
+		if err != nil {
+			continue
+		}
+		end := start
+		if dtend := event.property("DTEND"); dtend != nil {
+			if end, err = parseICalTime(dtend, berlin); err != nil {
+				continue
+			}
+		}
+		summary := event.value("SUMMARY")
+		if _, ok := bySummary[summary]; !ok {
+			summaries = append(summaries, summary)
+		}
+		bySummary[summary] = append(bySummary[summary], &compactItem{event: event, start: start.In(berlin), end: end.In(berlin)})
+	}
+
+	// The first event of a series is replaced by the master, the others are dropped
+	masters := make(map[*icalComponent]*compactSeries)
+	drop := make(map[*icalComponent]bool)
+	for _, summary := range summaries {
+		items := bySummary[summary]
+		slices.SortStableFunc(items, func(a, b *compactItem) int { return a.start.Compare(b.start) })
+
+		slots := make(map[string][]*compactItem)
+		var slotKeys []string
+		for _, item := range items {
+			key := fmt.Sprintf("%s|%d|%s|%v", item.event.value("LOCATION"), item.start.Weekday(), item.start.Format("15:04:05"), item.end.Sub(item.start))
+			if _, ok := slots[key]; !ok {
+				slotKeys = append(slotKeys, key)
+			}
+			slots[key] = append(slots[key], item)
+		}
+
+		var series []*compactSeries
+		used := make(map[*compactItem]bool)
+		for _, key := range slotKeys {
+			s := newCompactSeries(slots[key])
+			if s == nil {
+				continue
+			}
+			series = append(series, s)
+			for _, item := range s.items {
+				used[item] = true
+			}
+		}
+
+		for _, item := range items {
+			if used[item] {
+				continue
+			}
+			if s, week := seriesForMovedItem(series, item); s != nil {
+				delete(s.missing, week)
+				override := item.event
+				override.set("UID", nil, s.items[0].event.value("UID"))
+				override.set("RECURRENCE-ID", map[string]string{"TZID": changeTimeZone}, formatLocalTime(s.first.AddDate(0, 0, 7*week)))
+				s.overrides = append(s.overrides, override)
+				drop[item.event] = true
+			} else if s := seriesForExtraItem(series, item); s != nil {
+				s.rdates = append(s.rdates, item.start)
+				drop[item.event] = true
+			}
+		}
+
+		for _, s := range series {
+			masters[s.items[0].event] = s
+			for _, item := range s.items[1:] {
+				drop[item.event] = true
+			}
+		}
+	}
+	if len(masters) == 0 {
+		return
+	}
+
+	var components []*icalComponent
+	hasZone := false
+	for _, component := range cal.components {
+		hasZone = hasZone || (component.name == "VTIMEZONE" && component.value("TZID") == changeTimeZone)
+		if s, ok := masters[component]; ok {
+			s.writeMaster()
+			components = append(components, component)
+			components = append(components, s.overrides...)
+			continue
+		}
+		if !drop[component] {
+			components = append(components, component)
+		}
+	}
+	if !hasZone {
+		components = append([]*icalComponent{berlinVTimezone}, components...)
+	}
+	cal.components = components
+}
+
+// Build a series from the items of a slot, or return nil if they are held in
+// too few weeks. A second item in the same week is left out.
+func newCompactSeries(items []*compactItem) *compactSeries {
+	s := &compactSeries{first: items[0].start, missing: make(map[int]bool)}
+	seen := make(map[int]bool)
+	for _, item := range items {
+		week := weeksBetween(s.first, item.start)
+		if seen[week] {
+			continue
+		}
+		seen[week] = true
+		s.items = append(s.items, item)
+		s.weeks = week + 1
+	}
+	if len(s.items) < compactMinWeeks {
+		return nil
+	}
+	for week := range s.weeks {
+		if !seen[week] {
+			s.missing[week] = true
+		}
+	}
+	return s
+}
+
+// Return the series that lacks a session in the week of item, so item is
+// that session moved to another time or room
+func seriesForMovedItem(series []*compactSeries, item *compactItem) (*compactSeries, int) {
+	for _, s := range series {
+		if week := weeksBetween(s.first, item.start); s.missing[week] {
+			return s, week
+		}
+	}
+	return nil, 0
+}
+
+// Return the series item could be an additional date of, with the same room
+// and duration
+func seriesForExtraItem(series []*compactSeries, item *compactItem) *compactSeries {
+	for _, s := range series {
+		// A duplicate of a session is no additional date
+		if week := weeksBetween(s.first, item.start); week >= 0 && week < s.weeks && s.first.AddDate(0, 0, 7*week).Equal(item.start) {
+			continue
+		}
+		template := s.items[0]
+		if template.event.value("LOCATION") == item.event.value("LOCATION") && template.end.Sub(template.start) == item.end.Sub(item.start) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Turn the first event of the series into the recurrence
+func (s *compactSeries) writeMaster() {
+	master := s.items[0].event
+	zone := map[string]string{"TZID": changeTimeZone}
+	master.set("DTSTART", zone, formatLocalTime(s.first))
+	if master.property("DTEND") != nil {
+		master.set("DTEND", zone, formatLocalTime(s.items[0].end))
+	}
+	master.set("RRULE", nil, fmt.Sprintf("FREQ=WEEKLY;COUNT=%d", s.weeks))
+
+	var exdates []string
+	for week := range s.weeks {
+		if s.missing[week] {
+			exdates = append(exdates, formatLocalTime(s.first.AddDate(0, 0, 7*week)))
+		}
+	}
+	if len(exdates) > 0 {
+		master.set("EXDATE", zone, strings.Join(exdates, ","))
+	}
+	if len(s.rdates) > 0 {
+		var rdates []string
+		for _, rdate := range s.rdates {
+			rdates = append(rdates, formatLocalTime(rdate))
+		}
+		master.set("RDATE", zone, strings.Join(rdates, ","))
+	}
+}
+
+// Return the number of whole weeks from the calendar day of a to that of b
+func weeksBetween(a, b time.Time) int {
+	dayA := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	dayB := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(dayB.Sub(dayA).Hours() / 24)
+	if days < 0 {
+		return -1 - (-days-1)/7
+	}
+	return days / 7
+}
+
+func formatLocalTime(t time.Time) string {
+	return t.Format("20060102T150405")
+}
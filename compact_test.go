@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A winter semester of Analysis I on Mondays across the October DST switch,
+// without the Christmas break, with one session moved to a Tuesday and an
+// extra session on a Thursday
+func compactTestCalendar(t *testing.T) string {
+	t.Helper()
+	var events []string
+	monday := berlinTime(t, "2025-10-13 08:15")
+	for week := range 18 {
+		start := monday.AddDate(0, 0, 7*week)
+		switch start.Format("2006-01-02") {
+		case "2025-12-22", "2025-12-29", "2026-01-05":
+			continue
+		case "2025-11-17":
+			events = append(events, "moved~01-10-0001-vl Analysis I~S2|02 C110~20251118T100000~20251118T114000")
+			continue
+		}
+		end := start.Add(100 * time.Minute)
+		events = append(events, fmt.Sprintf("a%d~01-10-0001-vl Analysis I~S1|01 A1~%s~%s", week, formatLocalTime(start), formatLocalTime(end)))
+	}
+	events = append(events,
+		"extra~01-10-0001-vl Analysis I~S1|01 A1~20251204T081500~20251204T095500",
+		"u1~01-10-0001-ue Analysis I~S1|01 A3~20251014T133000~20251014T151000",
+		"u2~01-10-0001-ue Analysis I~S1|01 A3~20251021T133000~20251021T151000",
+		"exam~Klausur Analysis I~S1|01 A1~20260216T100000~20260216T120000",
+	)
+	return changesTestCalendar(events...)
+}
+
+// Expand RRULE with COUNT, EXDATE, RDATE and overrides back into single
+// events, formatted like "summary|location|start|end" in UTC
+func expandTestEvents(t *testing.T, cal *icalComponent) []string {
+	t.Helper()
+	parse := func(prop *icalProperty, value string) time.Time {
+		parsed, err := parseICalTime(&icalProperty{params: prop.params, value: value}, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	format := func(event *icalComponent, start, end time.Time) string {
+		return fmt.Sprintf("%s|%s|%s|%s", event.value("SUMMARY"), event.value("LOCATION"), start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+	}
+
+	overrides := make(map[string]*icalComponent)
+	for _, event := range cal.children("VEVENT") {
+		if rid := event.property("RECURRENCE-ID"); rid != nil {
+			overrides[event.value("UID")+"/"+parse(rid, rid.value).UTC().String()] = event
+		}
+	}
+
+	var expanded []string
+	for _, event := range cal.children("VEVENT") {
+		if event.property("RECURRENCE-ID") != nil {
+			continue
+		}
+		dtstart, dtend := event.property("DTSTART"), event.property("DTEND")
+		start := parse(dtstart, dtstart.value)
+		duration := parse(dtend, dtend.value).Sub(start)
+		rrule := event.value("RRULE")
+		if rrule == "" {
+			expanded = append(expanded, format(event, start, start.Add(duration)))
+			continue
+		}
+		count, err := strconv.Atoi(strings.TrimPrefix(rrule, "FREQ=WEEKLY;COUNT="))
+		if err != nil {
+			t.Fatalf("unsupported RRULE %s", rrule)
+		}
+		var instances []time.Time
+		for week := range count {
+			instances = append(instances, start.In(berlin).AddDate(0, 0, 7*week))
+		}
+		if exdate := event.property("EXDATE"); exdate != nil {
+			for _, value := range strings.Split(exdate.value, ",") {
+				excluded := parse(exdate, value)
+				instances = slices.DeleteFunc(instances, func(t time.Time) bool { return t.Equal(excluded) })
+			}
+		}
+		if rdate := event.property("RDATE"); rdate != nil {
+			for _, value := range strings.Split(rdate.value, ",") {
+				instances = append(instances, parse(rdate, value))
+			}
+		}
+		for _, instance := range instances {
+			if override := overrides[event.value("UID")+"/"+instance.UTC().String()]; override != nil {
+				oStart, oEnd := override.property("DTSTART"), override.property("DTEND")
+				expanded = append(expanded, format(override, parse(oStart, oStart.value), parse(oEnd, oEnd.value)))
+				continue
+			}
+			expanded = append(expanded, format(event, instance, instance.Add(duration)))
+		}
+	}
+	slices.Sort(expanded)
+	return expanded
+}
+
+func TestCompactEventsExpandsToOriginal(t *testing.T) {
+	for _, utc := range []bool{false, true} {
+		cal, err := parseICalendar(compactTestCalendar(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		normalizeTimes(cal, utc)
+		original := expandTestEvents(t, cal)
+
+		compactEvents(cal)
+		compacted, err := parseICalendar(cal.serialize())
+		if err != nil {
+			t.Fatalf("compacted calendar doesn't parse: %v", err)
+		}
+		if got := expandTestEvents(t, compacted); !slices.Equal(got, original) {
+			t.Fatalf("utc=%v: expanded events differ\ngot:\n%s\nwant:\n%s", utc, strings.Join(got, "\n"), strings.Join(original, "\n"))
+		}
+
+		// The lecture, its moved session and three single events
+		if events := compacted.children("VEVENT"); len(events) != 5 {
+			t.Fatalf("utc=%v: expected 5 events, got %d", utc, len(events))
+		}
+		master := eventByUID(t, compacted, "a0")
+		want := map[string]string{
+			"DTSTART": "DTSTART;TZID=Europe/Berlin:20251013T081500",
+			"RRULE":   "RRULE:FREQ=WEEKLY;COUNT=18",
+			"EXDATE":  "EXDATE;TZID=Europe/Berlin:20251222T081500,20251229T081500,20260105T081500",
+			"RDATE":   "RDATE;TZID=Europe/Berlin:20251204T081500",
+		}
+		for name, line := range want {
+			if got := master.property(name); got == nil || got.String() != line {
+				t.Errorf("utc=%v: %s is %v, want %s", utc, name, got, line)
+			}
+		}
+		if zones := compacted.children("VTIMEZONE"); len(zones) != 1 {
+			t.Errorf("utc=%v: expected one VTIMEZONE, got %d", utc, len(zones))
+		}
+	}
+}
+
+func TestHttpTucanCompact(t *testing.T) {
+	acc := newAccount("test", t.TempDir())
+	if err := os.WriteFile(acc.icalPath(), []byte(compactTestCalendar(t)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	acc.calendar.Compact = &enabled
+
+	for query, want := range map[string]int{"": 5, "?compact=false": 19, "?compact=1": 5} {
+		rec := httptest.NewRecorder()
+		httpTucan(acc)(rec, httptest.NewRequest("GET", "/tucan.ics"+query, nil))
+		cal, err := parseICalendar(rec.Body.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(cal.children("VEVENT")); got != want {
+			t.Errorf("GET /tucan.ics%s: %d events, want %d", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,670 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is the complete configuration. It is read from an optional YAML file
+// and then overridden by environment variables.
+type config struct {
+	Port               string          `yaml:"port"`
+	UpdateInterval     duration        `yaml:"update_interval"`
+	MinRefreshInterval duration        `yaml:"min_refresh_interval"`
+	DataDir            string          `yaml:"data_dir"`
+	DebugLogin         bool            `yaml:"debug_login"`
+	AdminTokens        []secret        `yaml:"admin_tokens,omitempty"`
+	AdminTokensFile    string          `yaml:"admin_tokens_file,omitempty"`
+	Schedule           scheduleConfig  `yaml:"schedule,omitempty"`
+	Webhooks           []webhookConfig `yaml:"webhooks,omitempty"`
+	SMTP               smtpConfig      `yaml:"smtp,omitempty"`
+	Email              []emailConfig   `yaml:"email,omitempty"`
+	Reminders          *reminderConfig `yaml:"reminders,omitempty"`
+	Alarms             []alarmConfig   `yaml:"alarms,omitempty"`
+	Rewrite            []rewriteConfig `yaml:"rewrite,omitempty"`
+	Conflicts          *conflictConfig `yaml:"conflicts,omitempty"`
+	Exams              *examConfig     `yaml:"exams,omitempty"`
+	Deadlines          *deadlineConfig `yaml:"deadlines,omitempty"`
+	Grades             *gradeConfig    `yaml:"grades,omitempty"`
+	Calendar           calendarConfig  `yaml:"calendar,omitempty"`
+	Accounts           []accountConfig `yaml:"accounts"`
+
+	// Accept accounts without a TOTP ID, for the tokens command that finds it
+	optionalTOTPID bool
+	// The only account was made from the TUCAN_* variables
+	implicitAccount bool
+}
+
+// accountConfig is one TUCaN login. Every credential can also be read from a
+// file with the matching *_file setting.
+type accountConfig struct {
+	Name           string          `yaml:"name"`
+	Username       string          `yaml:"username,omitempty"`
+	UsernameFile   string          `yaml:"username_file,omitempty"`
+	Password       secret          `yaml:"password,omitempty"`
+	PasswordFile   string          `yaml:"password_file,omitempty"`
+	TOTP           secret          `yaml:"totp,omitempty"`
+	TOTPFile       string          `yaml:"totp_file,omitempty"`
+	TOTPID         string          `yaml:"totp_id,omitempty"`
+	TOTPIDFile     string          `yaml:"totp_id_file,omitempty"`
+	UpdateInterval duration        `yaml:"update_interval,omitempty"`
+	Schedule       *scheduleConfig `yaml:"schedule,omitempty"`
+	FeedTokens     []secret        `yaml:"feed_tokens,omitempty"`
+	FeedTokensFile string          `yaml:"feed_tokens_file,omitempty"`
+	APITokens      []secret        `yaml:"api_tokens,omitempty"`
+	APITokensFile  string          `yaml:"api_tokens_file,omitempty"`
+	BasicAuth      basicAuthConfig `yaml:"basic_auth,omitempty"`
+	Webhooks       []webhookConfig `yaml:"webhooks,omitempty"`
+	Email          []emailConfig   `yaml:"email,omitempty"`
+	Push           []pushConfig    `yaml:"push,omitempty"`
+	Reminders      *reminderConfig `yaml:"reminders,omitempty"`
+	Alarms         []alarmConfig   `yaml:"alarms,omitempty"`
+	Rewrite        []rewriteConfig `yaml:"rewrite,omitempty"`
+	Conflicts      *conflictConfig `yaml:"conflicts,omitempty"`
+	Exams          *examConfig     `yaml:"exams,omitempty"`
+	Deadlines      *deadlineConfig `yaml:"deadlines,omitempty"`
+	Grades         *gradeConfig    `yaml:"grades,omitempty"`
+	Calendar       calendarConfig  `yaml:"calendar,omitempty"`
+}
+
+type basicAuthConfig struct {
+	Username     string `yaml:"username,omitempty"`
+	UsernameFile string `yaml:"username_file,omitempty"`
+	Password     secret `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+// duration is a time.Duration written as "30m" or "2h" in the config file
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, use a value like 30m or 2h", text)
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// secret is a config value that is masked when the config is printed
+type secret string
+
+func (s secret) MarshalText() ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return []byte("********"), nil
+}
+
+func defaultConfig() config {
+	return config{
+		Port:               "8080",
+		UpdateInterval:     duration(2 * time.Hour),
+		MinRefreshInterval: duration(5 * time.Minute),
+		DataDir:            "data",
+	}
+}
+
+// Load the config file at path (if any), apply the environment overrides and
+// validate the result. All problems are reported at once.
+func loadConfig(path string, getenv func(string) string) (config, error) {
+	cfg, err := readConfig(path, getenv)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+// Read the config file and apply the environment variables without
+// validating the result
+func readConfig(path string, getenv func(string) string) (config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	return cfg, cfg.applyEnv(getenv)
+}
+
+// Environment variables take precedence over the config file. The TUCAN_*
+// variables describe a single account and can't be used with several accounts.
+func (cfg *config) applyEnv(getenv func(string) string) error {
+	var errs []error
+	if port := getenv("PORT"); port != "" {
+		cfg.Port = port
+	}
+	if interval := getenv("UPDATE_INTERVAL"); interval != "" {
+		if err := cfg.UpdateInterval.UnmarshalText([]byte(interval)); err != nil {
+			errs = append(errs, fmt.Errorf("UPDATE_INTERVAL: %w", err))
+		}
+	}
+	if interval := getenv("MIN_REFRESH_INTERVAL"); interval != "" {
+		if err := cfg.MinRefreshInterval.UnmarshalText([]byte(interval)); err != nil {
+			errs = append(errs, fmt.Errorf("MIN_REFRESH_INTERVAL: %w", err))
+		}
+	}
+	if tokens := getenv("ADMIN_TOKENS"); tokens != "" {
+		cfg.AdminTokens = nil
+		cfg.AdminTokensFile = ""
+		for _, token := range strings.Split(tokens, ",") {
+			cfg.AdminTokens = append(cfg.AdminTokens, secret(token))
+		}
+	}
+	if path := getenv("ADMIN_TOKENS_FILE"); path != "" {
+		cfg.AdminTokens = nil
+		cfg.AdminTokensFile = path
+	}
+	if urls := getenv("WEBHOOK_URLS"); urls != "" {
+		// Every URL shares the secret, use the config file for anything else
+		cfg.Webhooks = nil
+		for _, u := range strings.Split(urls, ",") {
+			cfg.Webhooks = append(cfg.Webhooks, webhookConfig{
+				URL:        strings.TrimSpace(u),
+				Secret:     secret(getenv("WEBHOOK_SECRET")),
+				SecretFile: getenv("WEBHOOK_SECRET_FILE"),
+			})
+		}
+	}
+	for name, setting := range map[string]*string{
+		"SMTP_HOST":            &cfg.SMTP.Host,
+		"SMTP_PORT":            &cfg.SMTP.Port,
+		"SMTP_FROM":            &cfg.SMTP.From,
+		"SMTP_TLS":             &cfg.SMTP.TLS,
+		"CALENDAR_NAME":        &cfg.Calendar.Name,
+		"CALENDAR_DESCRIPTION": &cfg.Calendar.Description,
+		"CALENDAR_TIMEZONE":    &cfg.Calendar.Timezone,
+		"CALENDAR_COLOR":       &cfg.Calendar.Color,
+		"CALENDAR_TIMES":       &cfg.Calendar.Times,
+	} {
+		if value := getenv(name); value != "" {
+			*setting = value
+		}
+	}
+	overrideSetting(&cfg.SMTP.Username, &cfg.SMTP.UsernameFile, getenv("SMTP_USERNAME"), getenv("SMTP_USERNAME_FILE"))
+	overrideSetting((*string)(&cfg.SMTP.Password), &cfg.SMTP.PasswordFile, getenv("SMTP_PASSWORD"), getenv("SMTP_PASSWORD_FILE"))
+	if to := getenv("EMAIL_TO"); to != "" {
+		cfg.Email = []emailConfig{{To: splitSecretList(to)}}
+	}
+	if dataDir := getenv("DATA_DIR"); dataDir != "" {
+		cfg.DataDir = dataDir
+	}
+	if debug := getenv("DEBUG_LOGIN"); debug != "" {
+		parsed, err := strconv.ParseBool(debug)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("DEBUG_LOGIN: invalid boolean %q", debug))
+		}
+		cfg.DebugLogin = parsed
+	}
+
+	// Every account setting can also be read from a file with the *_FILE variant
+	accountVars := []string{"TUCAN_USERNAME", "TUCAN_PASSWORD", "TUCAN_TOTP", "TUCAN_TOTP_ID", "FEED_TOKENS", "BASIC_AUTH_USERNAME", "BASIC_AUTH_PASSWORD"}
+	var set []string
+	for _, name := range accountVars {
+		for _, variant := range []string{name, name + "_FILE"} {
+			if getenv(variant) != "" {
+				set = append(set, variant)
+			}
+		}
+		if getenv(name) != "" && getenv(name+"_FILE") != "" {
+			errs = append(errs, fmt.Errorf("set either %s or %s_FILE, not both", name, name))
+		}
+	}
+	if len(set) == 0 {
+		return errors.Join(errs...)
+	}
+	if len(cfg.Accounts) > 1 {
+		errs = append(errs, fmt.Errorf("%s can't be used with %d accounts in the config file", strings.Join(set, ", "), len(cfg.Accounts)))
+		return errors.Join(errs...)
+	}
+	if len(cfg.Accounts) == 0 {
+		cfg.Accounts = append(cfg.Accounts, accountConfig{Name: "default"})
+		cfg.implicitAccount = true
+	}
+
+	acc := &cfg.Accounts[0]
+	overrideSetting(&acc.Username, &acc.UsernameFile, getenv("TUCAN_USERNAME"), getenv("TUCAN_USERNAME_FILE"))
+	overrideSetting((*string)(&acc.Password), &acc.PasswordFile, getenv("TUCAN_PASSWORD"), getenv("TUCAN_PASSWORD_FILE"))
+	overrideSetting((*string)(&acc.TOTP), &acc.TOTPFile, getenv("TUCAN_TOTP"), getenv("TUCAN_TOTP_FILE"))
+	overrideSetting(&acc.TOTPID, &acc.TOTPIDFile, getenv("TUCAN_TOTP_ID"), getenv("TUCAN_TOTP_ID_FILE"))
+	overrideSetting(&acc.BasicAuth.Username, &acc.BasicAuth.UsernameFile, getenv("BASIC_AUTH_USERNAME"), getenv("BASIC_AUTH_USERNAME_FILE"))
+	overrideSetting((*string)(&acc.BasicAuth.Password), &acc.BasicAuth.PasswordFile, getenv("BASIC_AUTH_PASSWORD"), getenv("BASIC_AUTH_PASSWORD_FILE"))
+	if tokens := getenv("FEED_TOKENS"); tokens != "" {
+		// A comma separated list so tokens can be rotated by adding the new one before removing the old one
+		acc.FeedTokens = nil
+		acc.FeedTokensFile = ""
+		for _, token := range strings.Split(tokens, ",") {
+			acc.FeedTokens = append(acc.FeedTokens, secret(token))
+		}
+	}
+	if path := getenv("FEED_TOKENS_FILE"); path != "" {
+		acc.FeedTokens = nil
+		acc.FeedTokensFile = path
+	}
+	return errors.Join(errs...)
+}
+
+// Override a setting and its file variant, the one set in the environment wins
+func overrideSetting(value, path *string, envValue, envPath string) {
+	if envValue != "" {
+		*value = envValue
+		*path = ""
+	}
+	if envPath != "" {
+		*value = ""
+		*path = envPath
+	}
+}
+
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (cfg *config) validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		fail("port", "invalid port %q", cfg.Port)
+	}
+	if cfg.UpdateInterval <= 0 {
+		fail("update_interval", "must be positive")
+	}
+	if _, err := newSchedule(cfg.Schedule, time.Duration(cfg.UpdateInterval)); err != nil {
+		errs = append(errs, prefixErrors("schedule.", err)...)
+	}
+	errs = append(errs, validateWebhooks("webhooks", cfg.Webhooks)...)
+	errs = append(errs, validateEmailNotifiers("email", cfg.Email)...)
+	if cfg.Reminders != nil {
+		if _, err := newReminders(*cfg.Reminders); err != nil {
+			errs = append(errs, prefixErrors("reminders.", err)...)
+		}
+	}
+	if _, err := newAlarmRules(cfg.Alarms); err != nil {
+		errs = append(errs, prefixErrors("alarms", err)...)
+	}
+	if _, err := newRewriteRules(cfg.Rewrite); err != nil {
+		errs = append(errs, prefixErrors("rewrite", err)...)
+	}
+	if cfg.Conflicts != nil {
+		if err := cfg.Conflicts.validate(); err != nil {
+			errs = append(errs, prefixErrors("conflicts.", err)...)
+		}
+	}
+	if cfg.Exams != nil {
+		if err := cfg.Exams.validate(); err != nil {
+			errs = append(errs, prefixErrors("exams.", err)...)
+		}
+	}
+	if cfg.Deadlines != nil {
+		if err := cfg.Deadlines.validate(); err != nil {
+			errs = append(errs, prefixErrors("deadlines.", err)...)
+		}
+	}
+	if err := cfg.Calendar.validate(); err != nil {
+		errs = append(errs, prefixErrors("calendar.", err)...)
+	}
+	emailUsed := len(cfg.Email) > 0
+	if cfg.MinRefreshInterval < 0 {
+		fail("min_refresh_interval", "must not be negative")
+	}
+	if cfg.DataDir == "" {
+		fail("data_dir", "must not be empty")
+	}
+	// Admin tokens can't be feed or API tokens, they refresh every account
+	adminTokens := make(map[string]bool)
+	for _, token := range cfg.AdminTokens {
+		if token := strings.TrimSpace(string(token)); token != "" {
+			adminTokens[token] = true
+		}
+	}
+	if cfg.AdminTokensFile != "" {
+		if len(cfg.AdminTokens) > 0 {
+			fail("admin_tokens", "set either admin_tokens or admin_tokens_file, not both")
+		}
+		content, err := readSecretFile(cfg.AdminTokensFile)
+		if err != nil {
+			fail("admin_tokens_file", "%v", err)
+		}
+		for _, token := range splitSecretList(content) {
+			adminTokens[token] = true
+		}
+	}
+	if len(cfg.Accounts) == 0 {
+		errs = append(errs, errors.New("no accounts configured, set TUCAN_USERNAME, TUCAN_PASSWORD, TUCAN_TOTP and TUCAN_TOTP_ID or add accounts to the config file"))
+	}
+
+	names := make(map[string]bool)
+	tokens := make(map[string]string)
+	for i := range cfg.Accounts {
+		acc := &cfg.Accounts[i]
+		field := fmt.Sprintf("accounts[%d]", i)
+
+		acc.Name = strings.TrimSpace(acc.Name)
+		acc.TOTPID = strings.TrimSpace(acc.TOTPID)
+		if !accountNamePattern.MatchString(acc.Name) {
+			fail(field+".name", "%q must only contain letters, digits, '-' and '_'", acc.Name)
+		} else if names[acc.Name] {
+			fail(field+".name", "duplicate account name %q", acc.Name)
+		}
+		names[acc.Name] = true
+
+		// Read settings given as files so their content is validated as well
+		resolve := func(name, value, path string) string {
+			if path == "" {
+				if value == "" {
+					fail(field+"."+name, "must not be empty")
+				}
+				return value
+			}
+			if value != "" {
+				fail(field+"."+name, "set either %s or %s_file, not both", name, name)
+			}
+			content, err := readSecretFile(path)
+			if err != nil {
+				fail(field+"."+name+"_file", "%v", err)
+			}
+			return content
+		}
+		resolve("username", acc.Username, acc.UsernameFile)
+		resolve("password", string(acc.Password), acc.PasswordFile)
+		if totp := resolve("totp", string(acc.TOTP), acc.TOTPFile); totp != "" {
+			if _, err := parseTOTP(totp); err != nil {
+				fail(field+".totp", "%v", err)
+			}
+		}
+		if !cfg.optionalTOTPID || acc.TOTPID != "" || acc.TOTPIDFile != "" {
+			resolve("totp_id", acc.TOTPID, acc.TOTPIDFile)
+		}
+		if acc.UpdateInterval < 0 {
+			fail(field+".update_interval", "must be positive")
+		}
+		if acc.Schedule != nil {
+			if _, err := newSchedule(*acc.Schedule, time.Duration(cfg.UpdateInterval)); err != nil {
+				errs = append(errs, prefixErrors(field+".schedule.", err)...)
+			}
+		}
+
+		errs = append(errs, validateWebhooks(field+".webhooks", acc.Webhooks)...)
+		errs = append(errs, validateEmailNotifiers(field+".email", acc.Email)...)
+		emailUsed = emailUsed || len(acc.Email) > 0
+		errs = append(errs, validatePush(field+".push", acc.Push)...)
+		if acc.Reminders != nil {
+			if _, err := newReminders(*acc.Reminders); err != nil {
+				errs = append(errs, prefixErrors(field+".reminders.", err)...)
+			}
+		}
+		if _, err := newAlarmRules(acc.Alarms); err != nil {
+			errs = append(errs, prefixErrors(field+".alarms", err)...)
+		}
+		if _, err := newRewriteRules(acc.Rewrite); err != nil {
+			errs = append(errs, prefixErrors(field+".rewrite", err)...)
+		}
+		if acc.Conflicts != nil {
+			if err := acc.Conflicts.validate(); err != nil {
+				errs = append(errs, prefixErrors(field+".conflicts.", err)...)
+			}
+		}
+		if acc.Exams != nil {
+			if err := acc.Exams.validate(); err != nil {
+				errs = append(errs, prefixErrors(field+".exams.", err)...)
+			}
+		}
+		if acc.Deadlines != nil {
+			if err := acc.Deadlines.validate(); err != nil {
+				errs = append(errs, prefixErrors(field+".deadlines.", err)...)
+			}
+		}
+		if err := acc.Calendar.validate(); err != nil {
+			errs = append(errs, prefixErrors(field+".calendar.", err)...)
+		}
+
+		var accountTokens []secret
+		for _, token := range acc.FeedTokens {
+			token = secret(strings.TrimSpace(string(token)))
+			if token != "" {
+				accountTokens = append(accountTokens, token)
+			}
+		}
+		acc.FeedTokens = accountTokens
+		tokenList := acc.FeedTokens
+		if acc.FeedTokensFile != "" {
+			if len(acc.FeedTokens) > 0 {
+				fail(field+".feed_tokens", "set either feed_tokens or feed_tokens_file, not both")
+			}
+			content, err := readSecretFile(acc.FeedTokensFile)
+			if err != nil {
+				fail(field+".feed_tokens_file", "%v", err)
+			}
+			tokenList = nil
+			for _, token := range splitSecretList(content) {
+				tokenList = append(tokenList, secret(token))
+			}
+		}
+		for _, token := range tokenList {
+			if other, ok := tokens[string(token)]; ok {
+				fail(field+".feed_tokens", "token is already used by account %q", other)
+			}
+			if adminTokens[string(token)] {
+				fail(field+".feed_tokens", "token is already used as an admin token")
+			}
+			tokens[string(token)] = acc.Name
+		}
+		if len(cfg.Accounts) > 1 && len(tokenList) == 0 {
+			fail(field+".feed_tokens", "required when there is more than one account")
+		}
+
+		// API tokens can't be feed tokens, those end up in calendar apps
+		apiTokens := acc.APITokens
+		if acc.APITokensFile != "" {
+			if len(acc.APITokens) > 0 {
+				fail(field+".api_tokens", "set either api_tokens or api_tokens_file, not both")
+			}
+			content, err := readSecretFile(acc.APITokensFile)
+			if err != nil {
+				fail(field+".api_tokens_file", "%v", err)
+			}
+			apiTokens = nil
+			for _, token := range splitSecretList(content) {
+				apiTokens = append(apiTokens, secret(token))
+			}
+		}
+		for _, token := range apiTokens {
+			token = secret(strings.TrimSpace(string(token)))
+			if token == "" {
+				continue
+			}
+			if other, ok := tokens[string(token)]; ok {
+				fail(field+".api_tokens", "token is already used by account %q", other)
+			}
+			if adminTokens[string(token)] {
+				fail(field+".api_tokens", "token is already used as an admin token")
+			}
+			tokens[string(token)] = acc.Name
+		}
+
+		basicUser := acc.BasicAuth.Username != "" || acc.BasicAuth.UsernameFile != ""
+		basicPassword := acc.BasicAuth.Password != "" || acc.BasicAuth.PasswordFile != ""
+		if basicUser != basicPassword {
+			fail(field+".basic_auth", "set both username and password, or neither")
+		} else if basicUser {
+			resolve("basic_auth.username", acc.BasicAuth.Username, acc.BasicAuth.UsernameFile)
+			resolve("basic_auth.password", string(acc.BasicAuth.Password), acc.BasicAuth.PasswordFile)
+		}
+	}
+
+	errs = append(errs, validateEmail(cfg.SMTP, emailUsed)...)
+	return errors.Join(errs...)
+}
+
+// Print the effective config as YAML with all secrets masked
+func (cfg config) print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Create the runtime accounts. Every account stores its data in its own
+// directory. Files named in the config are read again here, so errors can
+// still happen after validate.
+func (cfg config) buildAccounts() ([]*account, error) {
+	var accounts []*account
+	for _, accCfg := range cfg.Accounts {
+		acc := newAccount(accCfg.Name, filepath.Join(cfg.DataDir, accCfg.Name))
+		if cfg.implicitAccount {
+			// Where the calendar was before there were several accounts
+			acc.calendarFile = icalFile
+		}
+		acc.username = newSecretValue(accCfg.Username, accCfg.UsernameFile)
+		acc.password = newSecretValue(string(accCfg.Password), accCfg.PasswordFile)
+		acc.totpSeed = newSecretValue(string(accCfg.TOTP), accCfg.TOTPFile)
+		acc.totpID = newSecretValue(accCfg.TOTPID, accCfg.TOTPIDFile)
+		interval := time.Duration(accCfg.UpdateInterval)
+		if interval == 0 {
+			interval = time.Duration(cfg.UpdateInterval)
+		}
+		// An account without its own schedule uses the global one, with its
+		// update_interval as the default interval
+		scheduleCfg := cfg.Schedule
+		if accCfg.Schedule != nil {
+			scheduleCfg = *accCfg.Schedule
+		}
+		var err error
+		if acc.schedule, err = newSchedule(scheduleCfg, interval); err != nil {
+			return nil, fmt.Errorf("account %s: schedule: %w", acc.name, err)
+		}
+		acc.refresh.minInterval = time.Duration(cfg.MinRefreshInterval)
+		// Reminders are off unless configured, an account's settings replace the global ones
+		reminderCfg := cfg.Reminders
+		if accCfg.Reminders != nil {
+			reminderCfg = accCfg.Reminders
+		}
+		if reminderCfg != nil {
+			if acc.reminders, err = newReminders(*reminderCfg); err != nil {
+				return nil, fmt.Errorf("account %s: reminders: %w", acc.name, err)
+			}
+		}
+		// Exams, deadlines and results are only fetched if configured, like reminders
+		acc.exams = cfg.Exams
+		if accCfg.Exams != nil {
+			acc.exams = accCfg.Exams
+		}
+		acc.deadlines = cfg.Deadlines
+		if accCfg.Deadlines != nil {
+			acc.deadlines = accCfg.Deadlines
+		}
+		acc.grades = cfg.Grades
+		if accCfg.Grades != nil {
+			acc.grades = accCfg.Grades
+		}
+		// Conflicts are always listed, the settings only add travel times and flags
+		if accCfg.Conflicts != nil {
+			acc.conflicts = *accCfg.Conflicts
+		} else if cfg.Conflicts != nil {
+			acc.conflicts = *cfg.Conflicts
+		}
+		name := "TUCaN"
+		if accCfg.Name != "default" {
+			name += " " + accCfg.Name
+		}
+		acc.calendar = accCfg.Calendar.withDefaults(cfg.Calendar).withDefaults(calendarConfig{
+			Name:            name,
+			Description:     "Timetable exported from TUCaN",
+			Timezone:        changeTimeZone,
+			RefreshInterval: duration(interval),
+			ProdID:          defaultProdID,
+			Times:           timesLocal,
+		})
+		if acc.rooms, err = loadRooms(acc.calendar.RoomsFile); err != nil {
+			return nil, fmt.Errorf("account %s: calendar.rooms_file: %w", acc.name, err)
+		}
+		// The global alarm rules apply to every account
+		if acc.alarms, err = newAlarmRules(slices.Concat(cfg.Alarms, accCfg.Alarms)); err != nil {
+			return nil, fmt.Errorf("account %s: alarms: %w", acc.name, err)
+		}
+		// and the global rewrite rules run before the account's own
+		if acc.rewrites, err = newRewriteRules(slices.Concat(cfg.Rewrite, accCfg.Rewrite)); err != nil {
+			return nil, fmt.Errorf("account %s: rewrite: %w", acc.name, err)
+		}
+
+		var tokens []string
+		for _, token := range accCfg.FeedTokens {
+			tokens = append(tokens, string(token))
+		}
+		acc.auth.tokens = newSecretList(tokens, accCfg.FeedTokensFile)
+		for _, token := range acc.auth.tokenList() {
+			if len(token) < minFeedTokenLength {
+				acc.log.Printf("Warning: a feed token is shorter than %d characters and may be guessable", minFeedTokenLength)
+			}
+		}
+		var apiTokens []string
+		for _, token := range accCfg.APITokens {
+			apiTokens = append(apiTokens, string(token))
+		}
+		acc.apiTokens = newSecretList(apiTokens, accCfg.APITokensFile)
+		acc.auth.basicUser = newSecretValue(accCfg.BasicAuth.Username, accCfg.BasicAuth.UsernameFile)
+		acc.auth.basicPassword = newSecretValue(string(accCfg.BasicAuth.Password), accCfg.BasicAuth.PasswordFile)
+
+		// The global webhooks receive the notifications of every account
+		for _, hook := range slices.Concat(cfg.Webhooks, accCfg.Webhooks) {
+			acc.notifiers = append(acc.notifiers, newWebhookNotifier(hook, acc.deliveries))
+		}
+		for i, email := range slices.Concat(cfg.Email, accCfg.Email) {
+			notifier, err := newEmailNotifier(cfg.SMTP, email, acc.log)
+			if err != nil {
+				return nil, fmt.Errorf("account %s: email[%d]: %w", acc.name, i, err)
+			}
+			acc.notifiers = append(acc.notifiers, notifier)
+		}
+		for _, push := range accCfg.Push {
+			acc.notifiers = append(acc.notifiers, newPushNotifier(push))
+		}
+		accounts = append(accounts, acc)
+	}
+	return accounts, nil
+}
+
+// The admin tokens may trigger refreshes for every account
+func (cfg config) adminTokens() *secretList {
+	var tokens []string
+	for _, token := range cfg.AdminTokens {
+		tokens = append(tokens, string(token))
+	}
+	return newSecretList(tokens, cfg.AdminTokensFile)
+}
+
+// Split joined errors and prefix each with the name of the config section
+func prefixErrors(prefix string, err error) []error {
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			errs = append(errs, fmt.Errorf("%s%w", prefix, err))
+		}
+		return errs
+	}
+	return []error{fmt.Errorf("%s%w", prefix, err)}
+}
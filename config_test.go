@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testEnv(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func TestLoadConfigAccounts(t *testing.T) {
+	path := writeTestConfig(t, `
+update_interval: 1h
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+    update_interval: 30m
+    feed_tokens: [alice-token-0123456789]
+  - name: bob
+    username: cd34efgh
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0002
+    feed_tokens: [bob-token-0123456789]
+`)
+
+	cfg, err := loadConfig(path, testEnv(nil))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	accounts, err := cfg.buildAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("expected 2 accounts, got %d", len(accounts))
+	}
+	if accounts[0].schedule.interval != 30*time.Minute || accounts[1].schedule.interval != time.Hour {
+		t.Fatalf("unexpected update intervals: %v, %v", accounts[0].schedule.interval, accounts[1].schedule.interval)
+	}
+	if accounts[0].icalPath() == accounts[1].icalPath() {
+		t.Fatalf("accounts share calendar file %s", accounts[0].icalPath())
+	}
+	if acc := accountForToken(accounts, "bob-token-0123456789"); acc != accounts[1] {
+		t.Fatalf("expected bob's token to resolve to bob")
+	}
+}
+
+func TestLoadConfigEnvOnly(t *testing.T) {
+	cfg, err := loadConfig("", testEnv(map[string]string{
+		"PORT":            "9090",
+		"UPDATE_INTERVAL": "45m",
+		"TUCAN_USERNAME":  "ab12cdef",
+		"TUCAN_PASSWORD":  "secret",
+		"TUCAN_TOTP":      "jbsw y3dp ehpk 3pxp",
+		"TUCAN_TOTP_ID":   "TOTP0001",
+		"FEED_TOKENS":     "old-token-0123456789, new-token-0123456789",
+	}))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if cfg.Port != "9090" || time.Duration(cfg.UpdateInterval) != 45*time.Minute {
+		t.Fatalf("environment overrides not applied: %+v", cfg)
+	}
+	if len(cfg.Accounts) != 1 || cfg.Accounts[0].Name != "default" {
+		t.Fatalf("expected a single default account, got %+v", cfg.Accounts)
+	}
+	if len(cfg.Accounts[0].FeedTokens) != 2 || cfg.Accounts[0].FeedTokens[1] != "new-token-0123456789" {
+		t.Fatalf("unexpected feed tokens: %v", cfg.Accounts[0].FeedTokens)
+	}
+
+	// The calendar stays where it was before there were several accounts
+	accounts, err := cfg.buildAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := accounts[0].icalPath(); path != "merged_calendar.ics" {
+		t.Fatalf("expected the calendar in the working directory, got %s", path)
+	}
+}
+
+func TestLoadConfigValidationErrors(t *testing.T) {
+	path := writeTestConfig(t, `
+update_interval: 2 hours
+accounts:
+  - name: alice
+`)
+	if _, err := loadConfig(path, testEnv(nil)); err == nil || !strings.Contains(err.Error(), `invalid duration "2 hours"`) {
+		t.Fatalf("expected a duration error, got %v", err)
+	}
+
+	path = writeTestConfig(t, `
+admin_tokens: [admin-token-0123456789, admin-feed-token-0123456789]
+accounts:
+  - name: ../alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: "  "
+  - name: bob
+    username: cd34efgh
+    password: secret
+    totp: not-base32!
+    totp_id: TOTP0002
+    feed_tokens: [shared-token-0123456789, admin-feed-token-0123456789]
+    api_tokens: [admin-token-0123456789]
+  - name: bob
+    username: cd34efgh
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0003
+    feed_tokens: [shared-token-0123456789]
+    api_tokens: [shared-token-0123456789]
+`)
+	_, err := loadConfig(path, testEnv(map[string]string{"UPDATE_INTERVAL": "soon"}))
+	if err == nil || !strings.Contains(err.Error(), `UPDATE_INTERVAL: invalid duration "soon"`) {
+		t.Fatalf("expected an UPDATE_INTERVAL error, got %v", err)
+	}
+	_, err = loadConfig(path, testEnv(nil))
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		`accounts[0].name: "../alice"`,
+		"accounts[0].totp_id: must not be empty",
+		"accounts[0].feed_tokens: required",
+		"accounts[1].totp: malformed base32 TOTP secret",
+		"accounts[1].feed_tokens: token is already used as an admin token",
+		"accounts[1].api_tokens: token is already used as an admin token",
+		`accounts[2].name: duplicate account name "bob"`,
+		`accounts[2].feed_tokens: token is already used by account "bob"`,
+		`accounts[2].api_tokens: token is already used by account "bob"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadConfigRejectsUnknownFields(t *testing.T) {
+	path := writeTestConfig(t, "update_intervall: 1h\n")
+	if _, err := loadConfig(path, testEnv(nil)); err == nil || !strings.Contains(err.Error(), "update_intervall") {
+		t.Fatalf("expected an unknown field error, got %v", err)
+	}
+}
+
+func TestConfigPrintMasksSecrets(t *testing.T) {
+	cfg, err := loadConfig("", testEnv(map[string]string{
+		"TUCAN_USERNAME":      "ab12cdef",
+		"TUCAN_PASSWORD":      "hunter2",
+		"TUCAN_TOTP":          "JBSWY3DPEHPK3PXP",
+		"TUCAN_TOTP_ID":       "TOTP0001",
+		"FEED_TOKENS":         "feed-token-0123456789",
+		"BASIC_AUTH_USERNAME": "student",
+		"BASIC_AUTH_PASSWORD": "letmein",
+	}))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := cfg.print(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"hunter2", "JBSWY3DPEHPK3PXP", "feed-token-0123456789", "letmein"} {
+		if strings.Contains(out.String(), leaked) {
+			t.Fatalf("printed config contains secret %q:\n%s", leaked, out.String())
+		}
+	}
+	for _, want := range []string{"username: ab12cdef", "update_interval: 2h0m0s", "totp_id: TOTP0001"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("printed config is missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"username":    "ab12cdef\n",
+		"password":    "hunter2\n",
+		"totp":        "JBSWY3DPEHPK3PXP\n",
+		"totp_id":     "TOTP0001\n",
+		"feed_tokens": "feed-token-0123456789\nnext-token-0123456789\n",
+	}
+	env := make(map[string]string)
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		envName := "TUCAN_" + strings.ToUpper(name) + "_FILE"
+		if name == "feed_tokens" {
+			envName = "FEED_TOKENS_FILE"
+		}
+		env[envName] = path
+	}
+
+	cfg, err := loadConfig("", testEnv(env))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	accounts, err := cfg.buildAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc := accounts[0]
+	if acc.username.get() != "ab12cdef" || acc.password.get() != "hunter2" || acc.totpID.get() != "TOTP0001" {
+		t.Fatalf("secrets not read from files: %q %q %q", acc.username.get(), acc.password.get(), acc.totpID.get())
+	}
+	if !acc.auth.validToken("next-token-0123456789") {
+		t.Fatal("expected feed tokens to be read from file")
+	}
+
+	// Both variants at once and unreadable files are reported
+	env["TUCAN_PASSWORD"] = "hunter2"
+	env["TUCAN_TOTP_FILE"] = filepath.Join(dir, "missing")
+	_, err = loadConfig("", testEnv(env))
+	if err == nil {
+		t.Fatal("expected an error for conflicting settings")
+	}
+	if !strings.Contains(err.Error(), "set either TUCAN_PASSWORD or TUCAN_PASSWORD_FILE") {
+		t.Fatalf("expected a conflict error, got %v", err)
+	}
+	delete(env, "TUCAN_PASSWORD")
+	_, err = loadConfig("", testEnv(env))
+	if err == nil || !strings.Contains(err.Error(), "accounts[0].totp_file") {
+		t.Fatalf("expected an error for the missing TOTP file, got %v", err)
+	}
+}
+
+func TestBuildAccountsReportsErrors(t *testing.T) {
+	rooms := filepath.Join(t.TempDir(), "rooms.yaml")
+	if err := os.WriteFile(rooms, []byte("X1|01:\n  name: Testgebäude\n  lat: 49.87490\n  lon: 8.65820\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig("", testEnv(map[string]string{
+		"TUCAN_USERNAME": "ab12cdef",
+		"TUCAN_PASSWORD": "secret",
+		"TUCAN_TOTP":     "JBSWY3DPEHPK3PXP",
+		"TUCAN_TOTP_ID":  "TOTP0001",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Calendar.RoomsFile = rooms
+	if _, err := cfg.buildAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is read again, it may be gone since the config was validated
+	os.Remove(rooms)
+	if _, err := cfg.buildAccounts(); err == nil || !strings.Contains(err.Error(), "account default: calendar.rooms_file:") {
+		t.Fatalf("expected a rooms_file error, got %v", err)
+	}
+	cfg.Calendar.RoomsFile = ""
+	cfg.Alarms = []alarmConfig{{Courses: []string{"("}, Before: []duration{duration(time.Hour)}}}
+	if _, err := cfg.buildAccounts(); err == nil || !strings.Contains(err.Error(), "account default: alarms:") {
+		t.Fatalf("expected an alarms error, got %v", err)
+	}
+}
+
+func TestOptionalTOTPID(t *testing.T) {
+	env := testEnv(map[string]string{
+		"TUCAN_USERNAME": "ab12cdef",
+		"TUCAN_PASSWORD": "secret",
+		"TUCAN_TOTP":     "JBSWY3DPEHPK3PXP",
+	})
+	if _, err := loadConfig("", env); err == nil || !strings.Contains(err.Error(), "accounts[0].totp_id: must not be empty") {
+		t.Fatalf("expected the TOTP ID to be required, got %v", err)
+	}
+
+	// The tokens command finds the TOTP ID, so it may be missing there
+	cfg, err := readConfig("", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.optionalTOTPID = true
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("expected the config to be valid without a TOTP ID, got %v", err)
+	}
+}
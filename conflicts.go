@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ways to mark conflicting events in the feed
+const (
+	flagCategory = "category"
+	flagPrefix   = "prefix"
+	flagOff      = "off"
+)
+
+// conflictConfig controls how overlapping events are found and marked
+type conflictConfig struct {
+	// Time needed between events on different campuses of the room database
+	TravelTime duration `yaml:"travel_time,omitempty"`
+	// Mark conflicting events in the feed with a category or a summary prefix
+	Flag string `yaml:"flag,omitempty"`
+	// The category or prefix, "Conflict" and "⚠ " by default
+	Label string `yaml:"label,omitempty"`
+}
+
+func (cfg conflictConfig) validate() error {
+	var errs []error
+	if cfg.TravelTime < 0 {
+		errs = append(errs, errors.New("travel_time: must not be negative"))
+	}
+	if cfg.Flag != "" && cfg.Flag != flagCategory && cfg.Flag != flagPrefix && cfg.Flag != flagOff {
+		errs = append(errs, fmt.Errorf("flag: %q must be %s, %s or %s", cfg.Flag, flagCategory, flagPrefix, flagOff))
+	}
+	return errors.Join(errs...)
+}
+
+// Return the category or prefix conflicting events are marked with
+func (cfg conflictConfig) label(flag string) string {
+	switch {
+	case cfg.Label != "":
+		return cfg.Label
+	case flag == flagPrefix:
+		return "⚠ "
+	}
+	return "Conflict"
+}
+
+// conflict is a pair of events that overlap, or that are too close to get
+// from the campus of the first to that of the second. Start and End are the
+// overlap, or the gap between the events for a travel conflict.
+type conflict struct {
+	First  calendarEvent
+	Second calendarEvent
+	Start  time.Time
+	End    time.Time
+	Travel bool
+}
+
+// Describe the conflict, e.g. "Analysis I (S1|01 A1) overlaps Lineare Algebra
+// (S2|02 C110), Mon 20.10.2025 09:00–09:55"
+func (c conflict) String() string {
+	describe := func(event calendarEvent) string {
+		if event.Location == "" {
+			return event.Summary
+		}
+		return fmt.Sprintf("%s (%s)", event.Summary, event.Location)
+	}
+	if c.Travel {
+		gap := "no time"
+		if c.End.After(c.Start) {
+			gap = formatLead(c.End.Sub(c.Start))
+		}
+		return fmt.Sprintf("%s leaves %s to get to %s, %s", describe(c.First), gap, describe(c.Second), formatEventTime(c.Start, c.End, berlin))
+	}
+	return fmt.Sprintf("%s overlaps %s, %s", describe(c.First), describe(c.Second), formatEventTime(c.Start, c.End, berlin))
+}
+
+// Find every pair of overlapping events. With a travel time, events on
+// different campuses also conflict if the gap between them is shorter.
+// All-day events and duplicates of the same session are ignored.
+func findConflicts(events []calendarEvent, rooms roomDB, travel time.Duration) []conflict {
+	var timed []calendarEvent
+	seen := make(map[calendarEvent]bool)
+	for _, event := range events {
+		session := calendarEvent{Summary: event.Summary, Location: event.Location, Start: event.Start.UTC(), End: event.End.UTC()}
+		if event.End.After(event.Start) && !allDayEvent(event) && !seen[session] {
+			seen[session] = true
+			timed = append(timed, event)
+		}
+	}
+	slices.SortStableFunc(timed, func(a, b calendarEvent) int { return a.Start.Compare(b.Start) })
+
+	var conflicts []conflict
+	for i, a := range timed {
+		for _, b := range timed[i+1:] {
+			// Later events start even later
+			if !b.Start.Before(a.End.Add(travel)) {
+				break
+			}
+			if b.Start.Before(a.End) {
+				end := a.End
+				if b.End.Before(end) {
+					end = b.End
+				}
+				conflicts = append(conflicts, conflict{First: a, Second: b, Start: b.Start, End: end})
+				continue
+			}
+			campusA, campusB := rooms.campus(a.Location), rooms.campus(b.Location)
+			if campusA != "" && campusB != "" && campusA != campusB {
+				conflicts = append(conflicts, conflict{First: a, Second: b, Start: a.End, End: b.Start, Travel: true})
+			}
+		}
+	}
+	return conflicts
+}
+
+// Return the campus of the building of a room, empty if it is unknown
+func (db roomDB) campus(location string) string {
+	code, _, ok := parseRoom(location)
+	if !ok {
+		return ""
+	}
+	return db[code].Campus
+}
+
+// Events from midnight to midnight are whole days, e.g. holidays
+func allDayEvent(event calendarEvent) bool {
+	midnight := func(t time.Time) bool {
+		h, m, s := t.In(berlin).Clock()
+		return h == 0 && m == 0 && s == 0
+	}
+	return midnight(event.Start) && midnight(event.End) && event.End.Sub(event.Start) >= 23*time.Hour
+}
+
+// Mark the events of the conflicts with a category or a summary prefix
+func flagConflicts(cal *icalComponent, conflicts []conflict, flag, label string) {
+	if flag != flagCategory && flag != flagPrefix {
+		return
+	}
+	conflicting := make(map[string]bool)
+	for _, c := range conflicts {
+		conflicting[conflictKey(c.First.UID, c.First.Start)] = true
+		conflicting[conflictKey(c.Second.UID, c.Second.Start)] = true
+	}
+
+	for _, event := range cal.children("VEVENT") {
+		start, err := parseICalTime(event.property("DTSTART"), berlin)
+		if err != nil || !conflicting[conflictKey(event.value("UID"), start)] {
+			continue
+		}
+		if flag == flagPrefix {
+			if summary := event.property("SUMMARY"); summary != nil && !strings.HasPrefix(summary.value, escapeText(label)) {
+				summary.value = escapeText(label) + summary.value
+			}
+			continue
+		}
+		var categories []string
+		if existing := event.value("CATEGORIES"); existing != "" {
+			categories = splitEscaped(existing)
+		}
+		if !slices.Contains(categories, escapeText(label)) {
+			event.set("CATEGORIES", nil, strings.Join(append(categories, escapeText(label)), ","))
+		}
+	}
+}
+
+func conflictKey(uid string, start time.Time) string {
+	return uid + "|" + start.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Types of events as TUCaN names them
+const (
+	typeLecture    = "Vorlesung"
+	typeExercise   = "Übung"
+	typeIntegrated = "Integrierte Veranstaltung"
+	typeTutorial   = "Tutorium"
+	typeSeminar    = "Seminar"
+	typeLab        = "Praktikum"
+	typeProject    = "Projekt"
+	typeExam       = "Klausur"
+	// Registration deadlines, which aren't TUCaN events
+	typeDeadline = "Frist"
+)
+
+// courseInfo is what the title and description of a TUCaN event tell about
+// its course, e.g. "20-00-0005-iv Grundlagen der Informatik"
+type courseInfo struct {
+	Code       string `json:"code,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Group      string `json:"group,omitempty"`
+	Instructor string `json:"instructor,omitempty"`
+}
+
+// X- properties holding the course information in the merged calendar
+const (
+	propCourseCode  = "X-TUCAN-COURSE-CODE"
+	propCourseTitle = "X-TUCAN-COURSE-TITLE"
+	propEventType   = "X-TUCAN-EVENT-TYPE"
+	propGroup       = "X-TUCAN-GROUP"
+	propInstructor  = "X-TUCAN-INSTRUCTOR"
+)
+
+var (
+	// Course numbers like 20-00-0005-iv or 18-kn-1010-vl.03, the suffix is the
+	// type and an optional number the group
+	courseCodePattern = regexp.MustCompile(`(?i)^(\d{2}-[0-9a-z]{2}-[0-9a-z]{4})(?:-([a-z]{2}))?(?:\.(\d+))?\b[\s:–-]*`)
+	groupPattern      = regexp.MustCompile(`(?i)(?:^|[\s,(–-]+)(?:Übungsgruppe|Uebungsgruppe|Gruppe|Gr\.|Group)\s*(\d+)\)?`)
+	instructorPattern = regexp.MustCompile(`(?im)^\s*(?:Dozent(?:in|en)?|Dozierende|Lehrende[rn]?|Leitung|Instructors?|Lecturers?)\s*:\s*(.+?)\s*$`)
+	academicPattern   = regexp.MustCompile(`(?:Prof\.|Dr\.|PD )[^,;()\n]*[^,;()\n\s]`)
+	typePrefixPattern = regexp.MustCompile(`(?i)^(?:Klausur|Prüfung|Vorlesung|Übung|Tutorium)\b[\s:–-]*`)
+	typeSuffixPattern = regexp.MustCompile(`(?i)[\s,:(–-]+(?:Klausur|Prüfung|Vorlesung|Übung|Tutorium)\)?$`)
+)
+
+var courseCodeTypes = map[string]string{
+	"vl": typeLecture,
+	"ue": typeExercise,
+	"iv": typeIntegrated,
+	"tt": typeTutorial,
+	"se": typeSeminar,
+	"ps": typeSeminar,
+	"pr": typeLab,
+	"pj": typeProject,
+}
+
+// Words naming a type, checked in this order so "Klausur zur Übung" is an exam
+var typeKeywords = []struct{ word, kind string }{
+	{"klausur", typeExam},
+	{"prüfung", typeExam},
+	{"exam", typeExam},
+	{"tutorium", typeTutorial},
+	{"übung", typeExercise},
+	{"uebung", typeExercise},
+	{"exercise", typeExercise},
+	{"praktikum", typeLab},
+	{"seminar", typeSeminar},
+	{"vorlesung", typeLecture},
+	{"lecture", typeLecture},
+}
+
+// Parse the course information out of an event's title and description
+func parseCourseInfo(summary, description string) courseInfo {
+	var info courseInfo
+	// Exams are often titled "Klausur <course number> <title>"
+	title := typePrefixPattern.ReplaceAllString(strings.TrimSpace(summary), "")
+
+	if m := courseCodePattern.FindStringSubmatch(title); m != nil {
+		info.Code = strings.ToLower(m[1])
+		info.Type = courseCodeTypes[strings.ToLower(m[2])]
+		info.Group = trimGroup(m[3])
+		title = title[len(m[0]):]
+	}
+	// An exam of a course keeps the course number of the lecture
+	if kind := keywordType(summary); kind == typeExam || info.Type == "" {
+		info.Type = kind
+	}
+	if info.Type == "" {
+		info.Type = keywordType(description)
+	}
+
+	for _, text := range []string{title, description} {
+		if m := groupPattern.FindStringSubmatch(text); m != nil && info.Group == "" {
+			info.Group = trimGroup(m[1])
+		}
+	}
+	title = groupPattern.ReplaceAllString(title, "")
+	title = typePrefixPattern.ReplaceAllString(title, "")
+	title = typeSuffixPattern.ReplaceAllString(title, "")
+	info.Title = strings.TrimSpace(title)
+
+	if m := instructorPattern.FindStringSubmatch(description); m != nil {
+		info.Instructor = m[1]
+	} else if m := academicPattern.FindString(description); m != "" {
+		info.Instructor = strings.TrimSpace(m)
+	}
+	return info
+}
+
+// Drop leading zeros, group "03" is group "3"
+func trimGroup(group string) string {
+	if trimmed := strings.TrimLeft(group, "0"); trimmed != "" {
+		return trimmed
+	}
+	return group
+}
+
+func keywordType(text string) string {
+	lower := strings.ToLower(text)
+	for _, keyword := range typeKeywords {
+		if strings.Contains(lower, keyword.word) {
+			return keyword.kind
+		}
+	}
+	return ""
+}
+
+// Return the kind reminders and alarms use for the type
+func (c courseInfo) kind() string {
+	switch c.Type {
+	case typeExam:
+		return kindExam
+	case typeExercise, typeTutorial:
+		return kindExercise
+	case typeDeadline:
+		return kindDeadline
+	}
+	return kindLecture
+}
+
+// Return the course information of a VEVENT, from the X- properties written
+// by annotateCourses or else parsed from its title and description
+func eventCourseInfo(event *icalComponent) courseInfo {
+	if event.property(propCourseCode) != nil || event.property(propEventType) != nil {
+		return courseInfo{
+			Code:       unescapeText(event.value(propCourseCode)),
+			Title:      unescapeText(event.value(propCourseTitle)),
+			Type:       unescapeText(event.value(propEventType)),
+			Group:      unescapeText(event.value(propGroup)),
+			Instructor: unescapeText(event.value(propInstructor)),
+		}
+	}
+	return parseCourseInfo(unescapeText(event.value("SUMMARY")), unescapeText(event.value("DESCRIPTION")))
+}
+
+// Add the course information of every event as X- properties and add the
+// type and course number to its CATEGORIES. The type of a deadline is kept,
+// its title names the course it belongs to.
+func annotateCourses(cal *icalComponent) {
+	for _, event := range cal.children("VEVENT") {
+		info := parseCourseInfo(unescapeText(event.value("SUMMARY")), unescapeText(event.value("DESCRIPTION")))
+		if unescapeText(event.value(propEventType)) == typeDeadline {
+			info.Type = typeDeadline
+		}
+		for _, field := range []struct{ name, value string }{
+			{propCourseCode, info.Code},
+			{propCourseTitle, info.Title},
+			{propEventType, info.Type},
+			{propGroup, info.Group},
+			{propInstructor, info.Instructor},
+		} {
+			if field.value != "" {
+				event.set(field.name, nil, escapeText(field.value))
+			}
+		}
+
+		var categories []string
+		if existing := event.value("CATEGORIES"); existing != "" {
+			categories = splitEscaped(existing)
+		}
+		for _, category := range []string{info.Type, info.Code} {
+			if category != "" && !slices.Contains(categories, escapeText(category)) {
+				categories = append(categories, escapeText(category))
+			}
+		}
+		if len(categories) > 0 {
+			event.set("CATEGORIES", nil, strings.Join(categories, ","))
+		}
+	}
+}
+
+// Split a list value at the commas that aren't escaped
+func splitEscaped(value string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ',':
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+// eventFilter selects events by their course information, every given field
+// must match one of its values
+type eventFilter struct {
+	types   []string
+	courses []string
+	groups  []string
+}
+
+// Read a filter from the type, course and group query parameters, each may
+// be repeated or comma separated
+func parseEventFilter(query url.Values) eventFilter {
+	list := func(name string) []string {
+		var values []string
+		for _, value := range query[name] {
+			for _, field := range strings.Split(value, ",") {
+				if field = strings.TrimSpace(field); field != "" {
+					values = append(values, strings.ToLower(field))
+				}
+			}
+		}
+		return values
+	}
+	return eventFilter{types: list("type"), courses: list("course"), groups: list("group")}
+}
+
+func (f eventFilter) empty() bool {
+	return len(f.types) == 0 && len(f.courses) == 0 && len(f.groups) == 0
+}
+
+// A course matches its number, a prefix of it, or a part of its title
+func (f eventFilter) matches(info courseInfo) bool {
+	if len(f.types) > 0 && !slices.Contains(f.types, strings.ToLower(info.Type)) && !slices.Contains(f.types, info.kind()) {
+		return false
+	}
+	if len(f.courses) > 0 && !slices.ContainsFunc(f.courses, func(course string) bool {
+		return (info.Code != "" && strings.HasPrefix(info.Code, course)) || strings.Contains(strings.ToLower(info.Title), course)
+	}) {
+		return false
+	}
+	if len(f.groups) > 0 && !slices.Contains(f.groups, strings.ToLower(info.Group)) {
+		return false
+	}
+	return true
+}
+
+// Remove the events the filter doesn't match
+func filterEvents(cal *icalComponent, f eventFilter) {
+	cal.components = slices.DeleteFunc(cal.components, func(c *icalComponent) bool {
+		return c.name == "VEVENT" && !f.matches(eventCourseInfo(c))
+	})
+}
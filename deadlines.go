@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Kinds of deadlines of a registration period
+const (
+	deadlineRegistration   = "registration"
+	deadlineDeregistration = "deregistration"
+)
+
+// How long the deadlines of a details page are reused by default. There is a
+// page per module and course and their periods rarely change, so they are
+// fetched less often than the export.
+const defaultDeadlineRefresh = 24 * time.Hour
+
+// deadlineConfig adds the registration deadlines of the user's modules and
+// courses to the calendar
+type deadlineConfig struct {
+	// CATEGORIES of the deadline events, "Frist" by default
+	Category string `yaml:"category,omitempty"`
+	// How long before a deadline its alarms go off
+	Alarms []duration `yaml:"alarms,omitempty"`
+	// How long the details pages are reused before they are fetched again
+	RefreshInterval duration `yaml:"refresh_interval,omitempty"`
+}
+
+func (cfg deadlineConfig) validate() error {
+	if err := validateAlarmTimes(cfg.Alarms); err != nil {
+		return err
+	}
+	if cfg.RefreshInterval < 0 {
+		return errors.New("refresh_interval: must not be negative")
+	}
+	return nil
+}
+
+func (cfg deadlineConfig) refreshInterval() time.Duration {
+	if cfg.RefreshInterval == 0 {
+		return defaultDeadlineRefresh
+	}
+	return time.Duration(cfg.RefreshInterval)
+}
+
+// deadline is the end of a registration or deregistration period of a
+// module or course
+type deadline struct {
+	Code   string    `json:"code,omitempty"`
+	Course string    `json:"course"`
+	Kind   string    `json:"kind"`
+	Phase  string    `json:"phase,omitempty"`
+	Opens  time.Time `json:"opens,omitzero"`
+	At     time.Time `json:"at"`
+	AllDay bool      `json:"all_day,omitempty"`
+}
+
+// deadlinePage is the deadlines of a details page and when they were fetched
+type deadlinePage struct {
+	fetched   time.Time
+	deadlines []deadline
+}
+
+// The overview pages linking to the details of the user's modules and courses
+func deadlineOverviewURLs(session string) []string {
+	return []string{
+		loginScript + "?APPNAME=CampusNet&PRGNAME=MYMODULES&ARGUMENTS=-N" + session + ",-N000275,",
+		loginScript + "?APPNAME=CampusNet&PRGNAME=PROFCOURSES&ARGUMENTS=-N" + session + ",-N000274,",
+	}
+}
+
+// Fetch the registration deadlines of the user's modules and courses with a
+// logged in client. The overview pages link to a details page per module or
+// course, which lists its registration periods. A details page is only
+// fetched again after the refresh interval. Pages that fail are logged and
+// keep their previous deadlines, or are left out if there are none.
+func (a *account) fetchDeadlines(client *http.Client, session string) ([]deadline, error) {
+	var details []string
+	for _, overview := range deadlineOverviewURLs(session) {
+		_, body, err := doRequest(client, "GET", overview, "", debugLogin)
+		if err != nil {
+			return nil, err
+		}
+		if accessDenied(body) {
+			return nil, errors.New("access denied")
+		}
+		links, err := detailLinks(body)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			if !slices.Contains(details, link) {
+				details = append(details, link)
+			}
+		}
+	}
+
+	pages := make(map[string]deadlinePage)
+	deadlines := []deadline{}
+	failed := 0
+	for _, link := range details {
+		key := detailPageKey(link)
+		page, ok := a.deadlinePages[key]
+		if !ok || time.Since(page.fetched) >= a.deadlines.refreshInterval() {
+			found, err := fetchDeadlinePage(client, link)
+			switch {
+			case err == nil:
+				page, ok = deadlinePage{fetched: time.Now(), deadlines: found}, true
+			case ok:
+				failed++
+				a.log.Printf("Error getting the deadlines of %s, keeping the previous ones: %v", key, err)
+			default:
+				failed++
+				a.log.Printf("Error getting the deadlines of %s, skipping it: %v", key, err)
+			}
+		}
+		if ok {
+			pages[key] = page
+			deadlines = append(deadlines, page.deadlines...)
+		}
+	}
+	if failed > 0 && len(pages) == 0 {
+		return nil, fmt.Errorf("all %d details pages failed", failed)
+	}
+	a.deadlinePages = pages
+	return deadlines, nil
+}
+
+// The links hold the session as their first argument, which changes with
+// every login
+var sessionArgumentPattern = regexp.MustCompile(`ARGUMENTS=-N\d+,`)
+
+func detailPageKey(link string) string {
+	return sessionArgumentPattern.ReplaceAllString(link, "ARGUMENTS=")
+}
+
+func fetchDeadlinePage(client *http.Client, link string) ([]deadline, error) {
+	_, body, err := doRequest(client, "GET", link, "", debugLogin)
+	if err != nil {
+		return nil, err
+	}
+	if accessDenied(body) {
+		return nil, errors.New("access denied")
+	}
+	return parseDeadlinePage(body)
+}
+
+// Return the absolute links to module and course details of an overview page
+func detailLinks(body string) ([]string, error) {
+	tables, err := parseHTMLTables(body)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, table := range tables {
+		for _, row := range table.rows {
+			for _, cell := range row {
+				for _, link := range cell.links {
+					if !strings.Contains(link, "PRGNAME=COURSEDETAILS") && !strings.Contains(link, "PRGNAME=MODULEDETAILS") {
+						continue
+					}
+					if !strings.HasPrefix(link, "http") {
+						link = baseURL + link
+					}
+					if !slices.Contains(links, link) {
+						links = append(links, link)
+					}
+				}
+			}
+		}
+	}
+	return links, nil
+}
+
+// Parse the registration periods of a module or course details page. The
+// course is named by the page's heading, the periods are the rows of the
+// table with columns for the end of registration and deregistration.
+// Periods without dates are left out.
+func parseDeadlinePage(body string) ([]deadline, error) {
+	title, err := pageHeading(body)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := parseHTMLTables(body)
+	if err != nil {
+		return nil, err
+	}
+	code := ""
+	if m := courseCodePattern.FindStringSubmatch(title); m != nil {
+		code = strings.ToLower(m[1])
+		title = strings.TrimSpace(title[len(m[0]):])
+	}
+
+	deadlines := []deadline{}
+	for _, table := range tables {
+		registrationCol := table.column("ende anmeldung", "anmeldung bis", "anmeldeschluss", "end of registration")
+		deregistrationCol := table.column("ende abmeldung", "abmeldung bis", "abmeldeschluss", "end of deregistration")
+		if registrationCol < 0 && deregistrationCol < 0 {
+			continue
+		}
+		phaseCol := table.column("phase", "block")
+		opensCol := table.column("start", "beginn", "anmeldung ab", "anmeldung von")
+
+		for _, row := range table.rows {
+			phase := strings.Join(cellAt(row, phaseCol).lines(), " ")
+			opens, _, _, err := parseTucanTime(cellAt(row, opensCol).text, berlin)
+			if err != nil {
+				opens = time.Time{}
+			}
+			for _, column := range []struct {
+				index int
+				kind  string
+			}{{registrationCol, deadlineRegistration}, {deregistrationCol, deadlineDeregistration}} {
+				at, _, allDay, err := parseTucanTime(cellAt(row, column.index).text, berlin)
+				if err != nil {
+					continue
+				}
+				deadlines = append(deadlines, deadline{
+					Code:   code,
+					Course: title,
+					Kind:   column.kind,
+					Phase:  phase,
+					Opens:  opens,
+					At:     at,
+					AllDay: allDay,
+				})
+			}
+		}
+	}
+	return deadlines, nil
+}
+
+// Return the text of the page's first <h1>
+func pageHeading(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	var heading *html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		if heading != nil {
+			return
+		}
+		if n.Type == html.ElementNode && n.Data == "h1" {
+			heading = n
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			find(child)
+		}
+	}
+	find(doc)
+	if heading == nil {
+		return "", errors.New("no heading found")
+	}
+	return strings.Join(parseHTMLCell(heading).lines(), " "), nil
+}
+
+// Return the title of the deadline, e.g. "Anmeldeschluss: Analysis I"
+func (d deadline) summary() string {
+	if d.Kind == deadlineDeregistration {
+		return "Abmeldeschluss: " + d.Course
+	}
+	return "Anmeldeschluss: " + d.Course
+}
+
+// Like an exam's, the UID is built without the date, so a period that ends
+// later is reported as moved
+func (d deadline) uid() string {
+	sum := sha256.Sum256([]byte(d.Code + "|" + d.Course + "|" + d.Kind + "|" + d.Phase))
+	return "deadline-" + hex.EncodeToString(sum[:8]) + "@tucan-ical"
+}
+
+// Return the deadline as a VEVENT with the category and alarms of cfg. A
+// deadline with a time is an event without duration, one without is a whole
+// day.
+func (d deadline) event(cfg deadlineConfig) *icalComponent {
+	event := &icalComponent{name: "VEVENT"}
+	event.set("UID", nil, d.uid())
+	event.set("DTSTAMP", nil, time.Now().UTC().Format("20060102T150405Z"))
+	event.set("SUMMARY", nil, escapeText(d.summary()))
+	if d.AllDay {
+		event.set("DTSTART", map[string]string{"VALUE": "DATE"}, d.At.Format("20060102"))
+	} else {
+		event.set("DTSTART", map[string]string{"TZID": changeTimeZone}, formatLocalTime(d.At))
+	}
+	event.set("TRANSP", nil, "TRANSPARENT")
+
+	var description []string
+	if d.Phase != "" {
+		description = append(description, "Phase: "+d.Phase)
+	}
+	if !d.Opens.IsZero() {
+		description = append(description, "Registration opens: "+d.Opens.In(berlin).Format("Mon 02.01.2006 15:04"))
+	}
+	if d.Code != "" {
+		description = append(description, "Course number: "+d.Code)
+	}
+	if len(description) > 0 {
+		event.set("DESCRIPTION", nil, escapeText(strings.Join(description, "\n")))
+	}
+
+	category := cfg.Category
+	if category == "" {
+		category = typeDeadline
+	}
+	event.set("CATEGORIES", nil, escapeText(category))
+	// annotateCourses keeps the type, the course title could name another one
+	event.set(propEventType, nil, escapeText(typeDeadline))
+	if d.Code != "" {
+		event.set(propCourseCode, nil, escapeText(d.Code))
+	}
+
+	addEventAlarms(event, cfg.Alarms)
+	return event
+}
+
+// Return the deadlines as a calendar to merge with the monthly exports
+func deadlinesCalendar(deadlines []deadline, cfg deadlineConfig) string {
+	cal := &icalComponent{name: "VCALENDAR", properties: []*icalProperty{{name: "VERSION", value: "2.0"}}}
+	for _, d := range deadlines {
+		cal.components = append(cal.components, d.event(cfg))
+	}
+	return cal.serialize()
+}
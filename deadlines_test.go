@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readDeadlineFixture(t *testing.T, name string) string {
+	t.Helper()
+	body, err := os.ReadFile("testdata/deadlines/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestDetailLinks(t *testing.T) {
+	links, err := detailLinks(readDeadlineFixture(t, "mymodules.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Analysis I is listed twice, the start page isn't a details page
+	if len(links) != 2 || !strings.HasPrefix(links[0], baseURL+"/scripts/mgrqispi.dll?") || !strings.Contains(links[1], "PRGNAME=COURSEDETAILS") {
+		t.Errorf("unexpected links %q", links)
+	}
+}
+
+func TestParseDeadlinePage(t *testing.T) {
+	deadlines, err := parseDeadlinePage(readDeadlineFixture(t, "moduledetails.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The exam registration can't be left
+	if len(deadlines) != 3 {
+		t.Fatalf("expected 3 deadlines, got %+v", deadlines)
+	}
+	for i, want := range []struct {
+		kind, phase, at string
+		allDay          bool
+	}{
+		{deadlineRegistration, "Direkte Zulassung", "2025-10-31 23:59", false},
+		{deadlineDeregistration, "Direkte Zulassung", "2025-11-14 23:59", false},
+		{deadlineRegistration, "Prüfungsanmeldung", "2026-01-15 00:00", true},
+	} {
+		d := deadlines[i]
+		if d.Code != "01-11-0001" || d.Course != "Analysis I" || d.Kind != want.kind || d.Phase != want.phase || !d.At.Equal(berlinTime(t, want.at)) || d.AllDay != want.allDay {
+			t.Errorf("deadline %d: unexpected %+v", i, d)
+		}
+	}
+	if !deadlines[0].Opens.Equal(berlinTime(t, "2025-10-01 00:00")) {
+		t.Errorf("unexpected start of registration %v", deadlines[0].Opens)
+	}
+
+	deadlines, err = parseDeadlinePage("<html><body><h1>Analysis I</h1><p>Keine Anmeldefristen</p></body></html>")
+	if err != nil || len(deadlines) != 0 {
+		t.Errorf("expected no deadlines, got %+v, %v", deadlines, err)
+	}
+}
+
+// fixtureTransport answers TUCaN requests by their PRGNAME, with an error for
+// the others
+type fixtureTransport struct {
+	pages    map[string]string
+	requests []string
+}
+
+func (f *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := req.URL.Query().Get("PRGNAME")
+	f.requests = append(f.requests, name)
+	body, ok := f.pages[name]
+	if !ok {
+		return nil, errors.New("connection reset")
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+func TestFetchDeadlinesSkipsAndCachesPages(t *testing.T) {
+	acc := newAccount("alice", t.TempDir())
+	acc.deadlines = &deadlineConfig{}
+	transport := &fixtureTransport{pages: map[string]string{
+		"MYMODULES":     readDeadlineFixture(t, "mymodules.html"),
+		"PROFCOURSES":   "<html><body><p>Keine Veranstaltungen</p></body></html>",
+		"MODULEDETAILS": readDeadlineFixture(t, "moduledetails.html"),
+	}}
+	client := &http.Client{Transport: transport}
+
+	// The course details fail, the module's deadlines are still returned
+	deadlines, err := acc.fetchDeadlines(client, "123456789012345")
+	if err != nil || len(deadlines) != 3 {
+		t.Fatalf("expected the module's 3 deadlines, got %+v, %v", deadlines, err)
+	}
+
+	// A new session reuses the module's page and tries the course again
+	transport.requests = nil
+	deadlines, err = acc.fetchDeadlines(client, "543210987654321")
+	if err != nil || len(deadlines) != 3 {
+		t.Fatalf("expected the cached deadlines, got %+v, %v", deadlines, err)
+	}
+	if strings.Join(transport.requests, ",") != "MYMODULES,PROFCOURSES,COURSEDETAILS" {
+		t.Fatalf("unexpected requests %v", transport.requests)
+	}
+
+	// Once every page fails the previous deadlines are kept by the caller
+	acc.deadlinePages = nil
+	delete(transport.pages, "MODULEDETAILS")
+	if _, err := acc.fetchDeadlines(client, "123456789012345"); err == nil {
+		t.Fatal("expected an error when every details page fails")
+	}
+}
+
+func TestDeadlinesCalendar(t *testing.T) {
+	// A course title naming another type doesn't change the kind
+	registration := deadline{Code: "20-00-0004", Course: "Praktikum in der Lehre", Kind: deadlineRegistration, Phase: "Direkte Zulassung",
+		Opens: berlinTime(t, "2025-10-01 00:00"), At: berlinTime(t, "2025-10-31 23:59")}
+	exam := deadline{Course: "Analysis I", Kind: deadlineDeregistration, At: berlinTime(t, "2026-01-15 00:00"), AllDay: true}
+	cfg := deadlineConfig{Alarms: []duration{duration(72 * time.Hour)}}
+
+	cal, err := parseICalendar(deadlinesCalendar([]deadline{registration, exam}, cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotateCourses(cal)
+	event := eventByUID(t, cal, registration.uid())
+	for prop, want := range map[string]string{
+		"SUMMARY":      "Anmeldeschluss: Praktikum in der Lehre",
+		"DTSTART":      "20251031T235900",
+		"CATEGORIES":   "Frist",
+		propEventType:  typeDeadline,
+		propCourseCode: "20-00-0004",
+	} {
+		if got := event.value(prop); got != want {
+			t.Errorf("%s = %q, want %q", prop, got, want)
+		}
+	}
+	if alarms := event.children("VALARM"); len(alarms) != 1 || alarms[0].value("TRIGGER") != "-P3D" {
+		t.Errorf("unexpected alarms %d", len(alarms))
+	}
+	if got := eventByUID(t, cal, exam.uid()); got.value("SUMMARY") != "Abmeldeschluss: Analysis I" || got.property("DTSTART").params["VALUE"] != "DATE" {
+		t.Errorf("unexpected all-day deadline %q", got.value("SUMMARY"))
+	}
+
+	// Deadlines are reminded three days and a day before by default
+	events := calendarEvents(cal)
+	if events[0].kind() != kindDeadline {
+		t.Fatalf("unexpected kind %q", events[0].kind())
+	}
+	r, err := newReminders(reminderConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	due := r.due(events, berlinTime(t, "2025-10-28 23:00"), berlinTime(t, "2025-10-29 00:00"))
+	if len(due) != 1 || due[0].String() != "Anmeldeschluss: Praktikum in der Lehre in 3 days, Fri 31.10.2025 23:59" {
+		t.Errorf("unexpected reminders %v", due)
+	}
+}
+
+func TestLoadConfigDeadlineAlarms(t *testing.T) {
+	path := writeTestConfig(t, `
+deadlines:
+  alarms: [72h, 1.5s]
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+`)
+	_, err := loadConfig(path, testEnv(nil))
+	if err == nil || !strings.Contains(err.Error(), "deadlines.alarms: 1.5s is not a whole number of seconds") {
+		t.Fatalf("expected an alarms error, got %v", err)
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// smtpConfig is the mail server used by every email notifier
+type smtpConfig struct {
+	Host         string `yaml:"host,omitempty"`
+	Port         string `yaml:"port,omitempty"`
+	Username     string `yaml:"username,omitempty"`
+	UsernameFile string `yaml:"username_file,omitempty"`
+	Password     secret `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+	From         string `yaml:"from,omitempty"`
+	// starttls (default), tls for implicit TLS on port 465, or none for a local relay
+	TLS string `yaml:"tls,omitempty"`
+}
+
+// emailConfig sends notifications to recipients. Changes are collected for
+// batch and sent as one digest, alerts are sent right away. Nothing is sent
+// during quiet hours, everything is held until they end.
+type emailConfig struct {
+	To               []string          `yaml:"to"`
+	Events           []string          `yaml:"events,omitempty"`
+	Batch            duration          `yaml:"batch,omitempty"`
+	QuietHours       *quietHoursConfig `yaml:"quiet_hours,omitempty"`
+	SubjectTemplate  string            `yaml:"subject_template,omitempty"`
+	BodyTemplate     string            `yaml:"body_template,omitempty"`
+	BodyTemplateFile string            `yaml:"body_template_file,omitempty"`
+}
+
+// quietHoursConfig is a daily period in Europe/Berlin, "22:00" to "07:00"
+// spans midnight
+type quietHoursConfig struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+type quietHours struct {
+	from, to int // minutes since midnight
+	loc      *time.Location
+}
+
+// emailNotifier sends the notifications of one account as mails
+type emailNotifier struct {
+	server  smtpServer
+	to      []string
+	events  []string
+	batch   time.Duration
+	quiet   *quietHours
+	subject *template.Template
+	body    *template.Template
+	log     *log.Logger
+
+	mu      sync.Mutex
+	pending []notification
+	timer   *time.Timer
+	due     time.Time
+}
+
+type smtpServer struct {
+	host     string
+	port     string
+	username *secretValue
+	password *secretValue
+	from     string
+	tls      string
+}
+
+// emailData is passed to the subject and body templates
+type emailData struct {
+	Account       string
+	Notifications []notification
+	Changes       []eventChange
+	Alerts        []notification
+}
+
+const defaultSubjectTemplate = `[tucan-ical] {{.Account}}: ` +
+	`{{if .Alerts}}{{(index .Alerts 0) | title}}{{if .Changes}} and {{len .Changes}} change(s){{end}}` +
+	`{{else}}{{len .Changes}} calendar change(s){{end}}`
+
+const defaultBodyTemplate = `{{range .Alerts}}{{. | title}} at {{formatTime .Time}}{{if .Error}}: {{.Error}}{{end}}
+{{end}}{{if and .Alerts .Changes}}
+{{end}}{{if .Changes}}Changes in the calendar of {{.Account}}:
+
+{{range .Changes}}- {{describe .}}
+{{end}}{{end}}`
+
+var emailTemplateFuncs = template.FuncMap{
+	"describe": func(change eventChange) string {
+		label, text := describeChange(change, berlin)
+		return label + ": " + text
+	},
+	"formatTime": func(t time.Time) string {
+		return t.In(berlin).Format("Mon 02.01.2006 15:04")
+	},
+	"title": notificationTitle,
+}
+
+func notificationTitle(n notification) string {
+	switch n.Type {
+	case notifyLoginFailed:
+		if strings.Contains(n.Error, errInvalidCredentials.Error()) {
+			return "Login failed, the username or password is wrong"
+		}
+		return "Login failed"
+	case notifyLoginRecovered:
+		return "Login works again"
+	case notifyExportFailed:
+		return "Calendar export keeps failing"
+	case notifyChanges:
+		return "Calendar changed"
+	case notifyReminder:
+		if n.Reminder != nil {
+			return fmt.Sprintf("Reminder: %s in %s", n.Reminder.Summary, formatLead(time.Duration(n.Reminder.Lead)))
+		}
+		return "Reminder"
+	case notifyGrade:
+		if n.Grade != nil {
+			return "Grade published: " + n.Grade.String()
+		}
+		return "Grade published"
+	}
+	return n.Type
+}
+
+func newEmailNotifier(server smtpConfig, cfg emailConfig, logger *log.Logger) (*emailNotifier, error) {
+	subject, body, err := parseEmailTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e := &emailNotifier{
+		server: smtpServer{
+			host:     server.Host,
+			port:     server.Port,
+			username: newSecretValue(server.Username, server.UsernameFile),
+			password: newSecretValue(string(server.Password), server.PasswordFile),
+			from:     server.From,
+			tls:      server.TLS,
+		},
+		to:      cfg.To,
+		events:  cfg.Events,
+		batch:   time.Duration(cfg.Batch),
+		subject: subject,
+		body:    body,
+		log:     logger,
+	}
+	if e.server.port == "" {
+		e.server.port = "587"
+		if e.server.tls == "tls" {
+			e.server.port = "465"
+		}
+	}
+	if cfg.QuietHours != nil {
+		if e.quiet, err = parseQuietHours(*cfg.QuietHours); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func parseEmailTemplates(cfg emailConfig) (*template.Template, *template.Template, error) {
+	subjectText := cfg.SubjectTemplate
+	if subjectText == "" {
+		subjectText = defaultSubjectTemplate
+	}
+	bodyText := cfg.BodyTemplate
+	if cfg.BodyTemplateFile != "" {
+		data, err := os.ReadFile(cfg.BodyTemplateFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("body_template_file: %w", err)
+		}
+		bodyText = string(data)
+	}
+	if bodyText == "" {
+		bodyText = defaultBodyTemplate
+	}
+
+	subject, err := template.New("subject").Funcs(emailTemplateFuncs).Parse(subjectText)
+	if err != nil {
+		return nil, nil, fmt.Errorf("subject_template: %w", err)
+	}
+	body, err := template.New("body").Funcs(emailTemplateFuncs).Parse(bodyText)
+	if err != nil {
+		return nil, nil, fmt.Errorf("body_template: %w", err)
+	}
+	return subject, body, nil
+}
+
+func parseQuietHours(cfg quietHoursConfig) (*quietHours, error) {
+	q := &quietHours{loc: berlin}
+	var err error
+	if q.from, err = parseClock(cfg.From, -1); err != nil || q.from < 0 {
+		return nil, fmt.Errorf("quiet_hours.from: invalid time %q, use HH:MM", cfg.From)
+	}
+	if q.to, err = parseClock(cfg.To, -1); err != nil || q.to < 0 {
+		return nil, fmt.Errorf("quiet_hours.to: invalid time %q, use HH:MM", cfg.To)
+	}
+	if q.from == q.to {
+		return nil, fmt.Errorf("quiet_hours.to: must differ from quiet_hours.from, %q would make the whole day quiet", cfg.To)
+	}
+	return q, nil
+}
+
+// Return when the quiet hours around t end, or the zero time if t isn't in them
+func (q *quietHours) until(t time.Time) time.Time {
+	local := t.In(q.loc)
+	minute := local.Hour()*60 + local.Minute()
+	quiet := minute >= q.from && minute < q.to
+	if q.to <= q.from {
+		quiet = minute >= q.from || minute < q.to
+	}
+	if !quiet {
+		return time.Time{}
+	}
+	end := time.Date(local.Year(), local.Month(), local.Day(), q.to/60, q.to%60, 0, 0, q.loc)
+	if !end.After(local) {
+		end = time.Date(local.Year(), local.Month(), local.Day()+1, q.to/60, q.to%60, 0, 0, q.loc)
+	}
+	return end
+}
+
+// Queue the notification and send it when it's due. Alerts are due right
+// away, changes after the batch period, and everything after quiet hours.
+func (e *emailNotifier) notify(n notification) error {
+	if !wantsNotification(e.events, n) {
+		return nil
+	}
+
+	e.mu.Lock()
+	e.pending = append(e.pending, n)
+	now := time.Now()
+	due := now
+	if n.Type == notifyChanges {
+		due = now.Add(e.batch)
+	}
+	if e.quiet != nil {
+		if end := e.quiet.until(now); !end.IsZero() {
+			due = end
+		}
+	}
+	if !due.After(now) {
+		if e.timer != nil {
+			e.timer.Stop()
+			e.timer = nil
+		}
+		e.mu.Unlock()
+		return e.flush()
+	}
+	// A flush that is due earlier sends this notification as well
+	if e.timer == nil || due.Before(e.due) {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		e.due = due
+		e.timer = time.AfterFunc(due.Sub(now), e.timerFired)
+	}
+	e.mu.Unlock()
+	return nil
+}
+
+// Send the batch, unless quiet hours started in the meantime
+func (e *emailNotifier) timerFired() {
+	if e.quiet != nil {
+		if end := e.quiet.until(time.Now()); !end.IsZero() {
+			e.mu.Lock()
+			e.due = end
+			e.timer = time.AfterFunc(time.Until(end), e.timerFired)
+			e.mu.Unlock()
+			return
+		}
+	}
+	if err := e.flush(); err != nil {
+		e.log.Printf("Failed to send email: %v", err)
+	}
+}
+
+// Send every pending notification in a single mail
+func (e *emailNotifier) flush() error {
+	e.mu.Lock()
+	pending := e.pending
+	e.pending = nil
+	e.timer = nil
+	e.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	data := emailData{Account: pending[0].Account, Notifications: pending}
+	for _, n := range pending {
+		if n.Type == notifyChanges {
+			data.Changes = append(data.Changes, n.Changes...)
+		} else {
+			data.Alerts = append(data.Alerts, n)
+		}
+	}
+	var subject, body bytes.Buffer
+	if err := e.subject.Execute(&subject, data); err != nil {
+		return fmt.Errorf("subject template: %w", err)
+	}
+	if err := e.body.Execute(&body, data); err != nil {
+		return fmt.Errorf("body template: %w", err)
+	}
+
+	msg, err := buildEmail(e.server.from, e.to, strings.TrimSpace(subject.String()), body.String(), time.Now())
+	if err != nil {
+		return err
+	}
+	if err := e.server.send(e.to, msg); err != nil {
+		return err
+	}
+	e.log.Printf("Sent an email with %d notification(s) to %d recipient(s)", len(pending), len(e.to))
+	return nil
+}
+
+// Build a plain text mail with quoted-printable body and encoded subject
+func buildEmail(from string, to []string, subject, body string, date time.Time) ([]byte, error) {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@tucan-ical>\r\n", newJobID())
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+func (s smtpServer) send(to []string, msg []byte) error {
+	addr := net.JoinHostPort(s.host, s.port)
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	tlsConfig := &tls.Config{ServerName: s.host}
+
+	var conn net.Conn
+	var err error
+	if s.tls == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.tls == "" || s.tls == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if user := s.username.get(); user != "" {
+		if err := client.Auth(smtp.PlainAuth("", user, s.password.get(), s.host)); err != nil {
+			return fmt.Errorf("authentication: %w", err)
+		}
+	}
+
+	sender, _ := mail.ParseAddress(s.from)
+	if err := client.Mail(sender.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		addr, _ := mail.ParseAddress(rcpt)
+		if err := client.Rcpt(addr.Address); err != nil {
+			return fmt.Errorf("recipient %s: %w", addr.Address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Check the mail server and email notifiers. The server is only required
+// when there is at least one email notifier.
+func validateEmail(server smtpConfig, used bool) []error {
+	var errs []error
+	if !used {
+		return nil
+	}
+	if server.Host == "" {
+		errs = append(errs, errors.New("smtp.host: required for email notifications"))
+	}
+	if _, err := mail.ParseAddress(server.From); err != nil {
+		errs = append(errs, fmt.Errorf("smtp.from: invalid address %q", server.From))
+	}
+	switch server.TLS {
+	case "", "starttls", "tls", "none":
+	default:
+		errs = append(errs, fmt.Errorf("smtp.tls: %q must be starttls, tls or none", server.TLS))
+	}
+	if server.Username != "" && server.UsernameFile != "" {
+		errs = append(errs, errors.New("smtp.username: set either username or username_file, not both"))
+	}
+	if server.Password != "" && server.PasswordFile != "" {
+		errs = append(errs, errors.New("smtp.password: set either password or password_file, not both"))
+	}
+	for name, path := range map[string]string{"username_file": server.UsernameFile, "password_file": server.PasswordFile} {
+		if path != "" {
+			if _, err := readSecretFile(path); err != nil {
+				errs = append(errs, fmt.Errorf("smtp.%s: %w", name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// Check the email notifiers of a config section, field is e.g. "email"
+func validateEmailNotifiers(field string, emails []emailConfig) []error {
+	var errs []error
+	for i, cfg := range emails {
+		name := fmt.Sprintf("%s[%d]", field, i)
+		if len(cfg.To) == 0 {
+			errs = append(errs, fmt.Errorf("%s.to: at least one recipient is required", name))
+		}
+		for _, to := range cfg.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				errs = append(errs, fmt.Errorf("%s.to: invalid address %q", name, to))
+			}
+		}
+		if err := validNotificationTypes(cfg.Events); err != nil {
+			errs = append(errs, fmt.Errorf("%s.events: %w", name, err))
+		}
+		if cfg.Batch < 0 {
+			errs = append(errs, fmt.Errorf("%s.batch: must not be negative", name))
+		}
+		if cfg.BodyTemplate != "" && cfg.BodyTemplateFile != "" {
+			errs = append(errs, fmt.Errorf("%s.body_template: set either body_template or body_template_file, not both", name))
+		}
+		if _, _, err := parseEmailTemplates(cfg); err != nil {
+			errs = append(errs, fmt.Errorf("%s.%w", name, err))
+		}
+		if cfg.QuietHours != nil {
+			if _, err := parseQuietHours(*cfg.QuietHours); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%w", name, err))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a mail received by the local SMTP stand-in
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// Start a minimal SMTP server that accepts every mail without TLS or auth
+func startTestSMTP(t *testing.T) (string, string, <-chan smtpMessage) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan smtpMessage, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSMTP(conn, messages)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, messages
+}
+
+func serveTestSMTP(conn net.Conn, messages chan<- smtpMessage) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP")
+
+	var msg smtpMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg = smtpMessage{from: strings.Trim(strings.TrimSpace(line)[10:], "<>")}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.data = data.String()
+			messages <- msg
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func testEmailNotifier(t *testing.T, cfg emailConfig) (*emailNotifier, <-chan smtpMessage) {
+	t.Helper()
+	host, port, messages := startTestSMTP(t)
+	server := smtpConfig{Host: host, Port: port, From: "tucan-ical <tucan@example.org>", TLS: "none"}
+	e, err := newEmailNotifier(server, cfg, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("newEmailNotifier failed: %v", err)
+	}
+	return e, messages
+}
+
+// Decode the subject and quoted-printable body of a received mail
+func readTestMail(t *testing.T, msg smtpMessage) (string, string) {
+	t.Helper()
+	parsed, err := mail.ReadMessage(strings.NewReader(msg.data))
+	if err != nil {
+		t.Fatalf("invalid mail %q: %v", msg.data, err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subject, strings.ReplaceAll(string(body), "\r\n", "\n")
+}
+
+func receiveTestMail(t *testing.T, messages <-chan smtpMessage) smtpMessage {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+	return smtpMessage{}
+}
+
+func TestEmailAlertIsSentRightAway(t *testing.T) {
+	e, messages := testEmailNotifier(t, emailConfig{To: []string{"alice@example.org", "Bob <bob@example.org>"}, Batch: duration(time.Hour)})
+
+	n := newNotification(notifyLoginFailed, "alice")
+	n.Error = "login: " + errInvalidCredentials.Error()
+	if err := e.notify(n); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+
+	msg := receiveTestMail(t, messages)
+	if msg.from != "tucan@example.org" || strings.Join(msg.to, ",") != "alice@example.org,bob@example.org" {
+		t.Fatalf("unexpected envelope %+v", msg)
+	}
+	subject, body := readTestMail(t, msg)
+	if subject != "[tucan-ical] alice: Login failed, the username or password is wrong" {
+		t.Fatalf("unexpected subject %q", subject)
+	}
+	if !strings.Contains(body, "incorrect username or password") {
+		t.Fatalf("body doesn't contain the error:\n%s", body)
+	}
+}
+
+func TestEmailBatchesChanges(t *testing.T) {
+	e, messages := testEmailNotifier(t, emailConfig{To: []string{"alice@example.org"}, Batch: duration(50 * time.Millisecond)})
+
+	start := time.Date(2025, 10, 20, 6, 15, 0, 0, time.UTC)
+	for _, location := range []string{"S2|02 C110", "S3|11 08"} {
+		n := newNotification(notifyChanges, "alice")
+		n.Changes = []eventChange{{Type: changeRoom, Summary: "Analysis I", OldLocation: "S1|01 A1", Location: location, Start: start}}
+		if err := e.notify(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subject, body := readTestMail(t, receiveTestMail(t, messages))
+	if subject != "[tucan-ical] alice: 2 calendar change(s)" {
+		t.Fatalf("unexpected subject %q", subject)
+	}
+	if !strings.Contains(body, "- Room changed: Analysis I, Mon 20.10.2025 08:15: S1|01 A1 → S2|02 C110\n") || !strings.Contains(body, "S3|11 08") {
+		t.Fatalf("digest doesn't list both changes:\n%s", body)
+	}
+	select {
+	case <-messages:
+		t.Fatal("expected a single digest")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEmailCustomTemplate(t *testing.T) {
+	e, messages := testEmailNotifier(t, emailConfig{
+		To:              []string{"alice@example.org"},
+		SubjectTemplate: "Stundenplan {{.Account}}",
+		BodyTemplate:    "{{range .Changes}}{{.Summary}}: {{.Location}}\n{{end}}",
+	})
+	n := newNotification(notifyChanges, "alice")
+	n.Changes = []eventChange{{Type: changeAdded, Summary: "Übung Analysis", Location: "S1|01 A3"}}
+	e.notify(n)
+
+	subject, body := readTestMail(t, receiveTestMail(t, messages))
+	if subject != "Stundenplan alice" || body != "Übung Analysis: S1|01 A3\n" {
+		t.Fatalf("unexpected mail %q: %q", subject, body)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	quiet, err := parseQuietHours(quietHoursConfig{From: "22:00", To: "07:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		at, want string
+	}{
+		{"2025-10-14 21:59", ""},
+		{"2025-10-14 22:00", "2025-10-15 07:00"},
+		{"2025-10-15 03:00", "2025-10-15 07:00"},
+		{"2025-10-15 07:00", ""},
+		// The night the clocks go back is an hour longer
+		{"2025-10-25 23:00", "2025-10-26 07:00"},
+	}
+	for _, tt := range tests {
+		got := quiet.until(berlinTime(t, tt.at))
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("until(%s) = %v, want not quiet", tt.at, got)
+			}
+			continue
+		}
+		if want := berlinTime(t, tt.want); !got.Equal(want) {
+			t.Errorf("until(%s) = %v, want %v", tt.at, got, want)
+		}
+	}
+}
+
+func TestQuietHoursSameTime(t *testing.T) {
+	_, err := parseQuietHours(quietHoursConfig{From: "22:00", To: "22:00"})
+	if err == nil || !strings.Contains(err.Error(), "quiet_hours.to: must differ from quiet_hours.from") {
+		t.Fatalf("expected an error for the same from and to, got %v", err)
+	}
+}
+
+func TestLoadConfigEmail(t *testing.T) {
+	path := writeTestConfig(t, `
+email:
+  - to: [not-an-address]
+    events: [changes]
+    body_template: "{{.Nope"
+    quiet_hours: {from: "22:00", to: "7am"}
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+`)
+	_, err := loadConfig(path, testEnv(nil))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		`email[0].to: invalid address "not-an-address"`,
+		"email[0].body_template:",
+		"email[0].quiet_hours.to: invalid time",
+		"smtp.host: required",
+		"smtp.from: invalid address",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+
+	cfg, err := loadConfig("", testEnv(map[string]string{
+		"TUCAN_USERNAME": "ab12cdef",
+		"TUCAN_PASSWORD": "secret",
+		"TUCAN_TOTP":     "JBSWY3DPEHPK3PXP",
+		"TUCAN_TOTP_ID":  "TOTP0001",
+		"SMTP_HOST":      "smtp.example.org",
+		"SMTP_FROM":      "tucan@example.org",
+		"EMAIL_TO":       "alice@example.org,bob@example.org",
+	}))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	accounts, err := cfg.buildAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	email, ok := accounts[0].notifiers[0].(*emailNotifier)
+	if !ok || len(email.to) != 2 || email.server.port != "587" {
+		t.Fatalf("unexpected notifiers %+v", accounts[0].notifiers)
+	}
+
+	// The body template file is read again when the accounts are built
+	template := filepath.Join(t.TempDir(), "body.tmpl")
+	if err := os.WriteFile(template, []byte("{{len .Changes}} change(s)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Email[0].BodyTemplateFile = template
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(template)
+	if _, err := cfg.buildAccounts(); err == nil || !strings.Contains(err.Error(), "email[0]: body_template_file:") {
+		t.Fatalf("expected a body_template_file error, got %v", err)
+	}
+}
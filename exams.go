@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How long an exam is assumed to take when TUCaN only gives its start
+const defaultExamLength = 2 * time.Hour
+
+// examConfig adds the exams of the "My exams" page to the calendar
+type examConfig struct {
+	// CATEGORIES of the exam events, "Prüfung" by default
+	Category string `yaml:"category,omitempty"`
+	// How long before an exam its alarms go off
+	Alarms []duration `yaml:"alarms,omitempty"`
+}
+
+func (cfg examConfig) validate() error {
+	return validateAlarmTimes(cfg.Alarms)
+}
+
+// exam is a row of the "My exams" page
+type exam struct {
+	Code   string    `json:"code,omitempty"`
+	Course string    `json:"course"`
+	Name   string    `json:"name,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	AllDay bool      `json:"all_day,omitempty"`
+	Room   string    `json:"room,omitempty"`
+	Status string    `json:"status,omitempty"`
+}
+
+func examsURL(session string) string {
+	return loginScript + "?APPNAME=CampusNet&PRGNAME=MYEXAMS&ARGUMENTS=-N" + session + ",-N000318,"
+}
+
+// Fetch the exams of the current semester with a logged in client
+func fetchExams(client *http.Client, session string) ([]exam, error) {
+	_, body, err := doRequest(client, "GET", examsURL(session), "", debugLogin)
+	if err != nil {
+		return nil, err
+	}
+	if accessDenied(body) {
+		return nil, errors.New("access denied")
+	}
+	return parseExamsPage(body)
+}
+
+// Parse the exams table of the "My exams" page. Its columns are found by
+// their headers, exams without a date yet are left out.
+func parseExamsPage(body string) ([]exam, error) {
+	tables, err := parseHTMLTables(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		courseCol := table.column("veranstaltung", "kursname", "modul", "course")
+		dateCol := table.column("datum", "termin", "date")
+		if courseCol < 0 || dateCol < 0 {
+			continue
+		}
+		codeCol := table.column("nr.", "nummer", "number")
+		nameCol := table.column("prüfung", "exam")
+		if nameCol == courseCol {
+			nameCol = -1
+		}
+		roomCol := table.column("raum", "ort", "room")
+		statusCol := table.column("status")
+
+		exams := []exam{}
+		for _, row := range table.rows {
+			start, end, allDay, err := parseTucanTime(cellAt(row, dateCol).text, berlin)
+			if err != nil {
+				continue
+			}
+			// Without an end the exam still blocks the time, e.g. for the conflicts
+			if !allDay && !end.After(start) {
+				end = start.Add(defaultExamLength)
+			}
+			lines := cellAt(row, courseCol).lines()
+			if len(lines) == 0 {
+				continue
+			}
+			e := exam{
+				Course: lines[0],
+				Start:  start,
+				End:    end,
+				AllDay: allDay,
+				Room:   strings.Join(cellAt(row, roomCol).lines(), ", "),
+				Status: strings.Join(cellAt(row, statusCol).lines(), " "),
+			}
+			// The course cell may start with the course number and name the exam below
+			if m := courseCodePattern.FindStringSubmatch(e.Course); m != nil {
+				e.Code = strings.ToLower(m[1])
+				e.Course = strings.TrimSpace(e.Course[len(m[0]):])
+			}
+			if m := courseCodePattern.FindStringSubmatch(cellAt(row, codeCol).text); m != nil {
+				e.Code = strings.ToLower(m[1])
+			}
+			if nameCol >= 0 {
+				e.Name = strings.Join(cellAt(row, nameCol).lines(), " ")
+			} else if len(lines) > 1 {
+				e.Name = strings.Join(lines[1:], " ")
+			}
+			exams = append(exams, e)
+		}
+		return exams, nil
+	}
+	return nil, errors.New("no exams table found")
+}
+
+// The UID leaves out the date and room, so a moved exam keeps it and shows up
+// as moved in the changes
+func (e exam) uid() string {
+	sum := sha256.Sum256([]byte(e.Code + "|" + e.Course + "|" + e.Name))
+	return "exam-" + hex.EncodeToString(sum[:8]) + "@tucan-ical"
+}
+
+// Return the exam as a VEVENT with the category and alarms of cfg
+func (e exam) event(cfg examConfig) *icalComponent {
+	event := &icalComponent{name: "VEVENT"}
+	event.set("UID", nil, e.uid())
+	event.set("DTSTAMP", nil, time.Now().UTC().Format("20060102T150405Z"))
+	event.set("SUMMARY", nil, escapeText("Prüfung: "+e.Course))
+	if e.AllDay {
+		event.set("DTSTART", map[string]string{"VALUE": "DATE"}, e.Start.Format("20060102"))
+		event.set("DTEND", map[string]string{"VALUE": "DATE"}, e.End.Format("20060102"))
+	} else {
+		zone := map[string]string{"TZID": changeTimeZone}
+		event.set("DTSTART", zone, formatLocalTime(e.Start))
+		event.set("DTEND", zone, formatLocalTime(e.End))
+	}
+	if e.Room != "" {
+		event.set("LOCATION", nil, escapeText(e.Room))
+	}
+
+	var description []string
+	if e.Name != "" {
+		description = append(description, "Exam: "+e.Name)
+	}
+	if e.Code != "" {
+		description = append(description, "Course number: "+e.Code)
+	}
+	if e.Status != "" {
+		description = append(description, "Status: "+e.Status)
+	}
+	if len(description) > 0 {
+		event.set("DESCRIPTION", nil, escapeText(strings.Join(description, "\n")))
+	}
+
+	category := cfg.Category
+	if category == "" {
+		category = "Prüfung"
+	}
+	event.set("CATEGORIES", nil, escapeText(category))
+	// annotateCourses keeps these, the title alone doesn't tell the course number
+	event.set(propEventType, nil, escapeText(typeExam))
+	if e.Code != "" {
+		event.set(propCourseCode, nil, escapeText(e.Code))
+	}
+
+	addEventAlarms(event, cfg.Alarms)
+	return event
+}
+
+// Return the exams as a calendar to merge with the monthly exports
+func examsCalendar(exams []exam, cfg examConfig) string {
+	cal := &icalComponent{name: "VCALENDAR", properties: []*icalProperty{{name: "VERSION", value: "2.0"}}}
+	for _, e := range exams {
+		cal.components = append(cal.components, e.event(cfg))
+	}
+	return cal.serialize()
+}
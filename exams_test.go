@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExamsPage(t *testing.T) {
+	body, err := os.ReadFile("testdata/exams/myexams.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exams, err := parseExamsPage(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lineare Algebra has no date yet
+	if len(exams) != 3 {
+		t.Fatalf("expected 3 exams, got %+v", exams)
+	}
+
+	ana := exams[0]
+	if ana.Code != "01-11-0001" || ana.Course != "Analysis I" || ana.Name != "Fachprüfung Klausur" {
+		t.Errorf("unexpected exam %+v", ana)
+	}
+	if !ana.Start.Equal(berlinTime(t, "2025-02-17 09:00")) || !ana.End.Equal(berlinTime(t, "2025-02-17 11:00")) || ana.AllDay {
+		t.Errorf("unexpected time %v–%v", ana.Start, ana.End)
+	}
+	if ana.Room != "S1|01 A1, S1|01 A2" || ana.Status != "angemeldet" {
+		t.Errorf("unexpected room %q or status %q", ana.Room, ana.Status)
+	}
+
+	fop := exams[1]
+	if fop.Code != "20-00-0004" || !fop.AllDay || !fop.Start.Equal(berlinTime(t, "2025-03-14 00:00")) || !fop.End.Equal(berlinTime(t, "2025-03-15 00:00")) {
+		t.Errorf("unexpected exam %+v", fop)
+	}
+
+	// Rechnernetze only has a start, it still takes up time
+	rn := exams[2]
+	if !rn.Start.Equal(berlinTime(t, "2025-02-19 14:00")) || !rn.End.Equal(berlinTime(t, "2025-02-19 16:00")) || rn.AllDay {
+		t.Errorf("unexpected time %v–%v", rn.Start, rn.End)
+	}
+	lecture := calendarEvent{Summary: "Tutorium", Start: berlinTime(t, "2025-02-19 15:00"), End: berlinTime(t, "2025-02-19 16:00")}
+	examEvent := calendarEvent{Summary: "Prüfung: " + rn.Course, Start: rn.Start, End: rn.End}
+	if conflicts := findConflicts([]calendarEvent{examEvent, lecture}, nil, 0); len(conflicts) != 1 {
+		t.Errorf("expected the exam to conflict with the tutorial, got %+v", conflicts)
+	}
+
+	if _, err := parseExamsPage("<html><body><table><tr><td>Keine Prüfungen</td></tr></table></body></html>"); err == nil {
+		t.Error("expected an error for a page without the exams table")
+	}
+}
+
+func TestParseTucanTime(t *testing.T) {
+	for _, tt := range []struct {
+		text       string
+		start, end string
+		allDay     bool
+	}{
+		{"Mo, 17. Feb. 2025 09:00-11:00", "2025-02-17 09:00", "2025-02-17 11:00", false},
+		{"17.02.2025 09:00 - 11:30 Uhr", "2025-02-17 09:00", "2025-02-17 11:30", false},
+		{"Do, 6. März 2025 14:00 bis 16:00", "2025-03-06 14:00", "2025-03-06 16:00", false},
+		{"31. Mai 2025 22:00–01:00", "2025-05-31 22:00", "2025-06-01 01:00", false},
+		{"14.03.2025", "2025-03-14 00:00", "2025-03-15 00:00", true},
+		{"01.10.2025 10:00", "2025-10-01 10:00", "2025-10-01 10:00", false},
+	} {
+		start, end, allDay, err := parseTucanTime(tt.text, berlin)
+		if err != nil {
+			t.Errorf("%q: %v", tt.text, err)
+			continue
+		}
+		if !start.Equal(berlinTime(t, tt.start)) || !end.Equal(berlinTime(t, tt.end)) || allDay != tt.allDay {
+			t.Errorf("%q: got %v–%v (all day %v)", tt.text, start, end, allDay)
+		}
+	}
+	for _, text := range []string{"noch nicht festgelegt", "32.13.2025", ""} {
+		if _, _, _, err := parseTucanTime(text, berlin); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+func TestExamsCalendar(t *testing.T) {
+	ana := exam{Code: "01-11-0001", Course: "Analysis I", Name: "Klausur", Room: "S1|01 A1", Status: "angemeldet",
+		Start: berlinTime(t, "2025-02-17 09:00"), End: berlinTime(t, "2025-02-17 11:00")}
+	cfg := examConfig{Alarms: []duration{duration(24 * time.Hour), duration(time.Hour)}}
+
+	cal, err := parseICalendar(examsCalendar([]exam{ana}, cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotateCourses(cal)
+	event := eventByUID(t, cal, ana.uid())
+	for prop, want := range map[string]string{
+		"SUMMARY":      "Prüfung: Analysis I",
+		"DTSTART":      "20250217T090000",
+		"LOCATION":     "S1|01 A1",
+		"CATEGORIES":   "Prüfung,Klausur",
+		propEventType:  typeExam,
+		propCourseCode: "01-11-0001",
+	} {
+		if got := event.value(prop); got != want {
+			t.Errorf("%s = %q, want %q", prop, got, want)
+		}
+	}
+	if alarms := event.children("VALARM"); len(alarms) != 2 || alarms[0].value("TRIGGER") != "-P1D" {
+		t.Errorf("unexpected alarms %d", len(alarms))
+	}
+
+	// A moved exam keeps its UID, so it is reported as moved
+	moved := ana
+	moved.Start, moved.End = berlinTime(t, "2025-02-18 09:00"), berlinTime(t, "2025-02-18 11:00")
+	if moved.uid() != ana.uid() {
+		t.Error("the UID changed with the date")
+	}
+	allDay := exam{Course: "FOP", Start: berlinTime(t, "2025-03-14 00:00"), End: berlinTime(t, "2025-03-15 00:00"), AllDay: true}
+	if got := examsCalendar([]exam{allDay}, examConfig{Category: "Exam"}); !strings.Contains(got, "DTSTART;VALUE=DATE:20250314") || !strings.Contains(got, "CATEGORIES:Exam") {
+		t.Errorf("unexpected all-day exam\n%s", got)
+	}
+}
+
+func TestLoadConfigExamAlarms(t *testing.T) {
+	path := writeTestConfig(t, `
+exams:
+  alarms: [24h, 1.5s]
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+`)
+	_, err := loadConfig(path, testEnv(nil))
+	if err == nil || !strings.Contains(err.Error(), "exams.alarms: 1.5s is not a whole number of seconds") {
+		t.Fatalf("expected an alarms error, got %v", err)
+	}
+}
@@ -6,4 +6,5 @@ require (
 	github.com/joho/godotenv v1.5.1
 	golang.org/x/net v0.39.0
 	golang.org/x/text v0.24.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const gradesFile = "grades.json"
+
+// gradeConfig enables notifications when a grade is published on the exam
+// results page
+type gradeConfig struct {
+	// Put the grade into the notification, by default it only says which
+	// exam has a grade
+	ShowGrade bool `yaml:"show_grade,omitempty"`
+}
+
+// examResult is a row of the exam results page. Grade is only set in
+// notifications with show_grade.
+type examResult struct {
+	Code   string `json:"code,omitempty"`
+	Course string `json:"course"`
+	Name   string `json:"name,omitempty"`
+	Grade  string `json:"grade,omitempty"`
+}
+
+func resultsURL(session string) string {
+	return loginScript + "?APPNAME=CampusNet&PRGNAME=EXAMRESULTS&ARGUMENTS=-N" + session + ",-N000325,"
+}
+
+// Fetch the exam results of the current semester with a logged in client
+func fetchResults(client *http.Client, session string) ([]examResult, error) {
+	_, body, err := doRequest(client, "GET", resultsURL(session), "", debugLogin)
+	if err != nil {
+		return nil, err
+	}
+	if accessDenied(body) {
+		return nil, errors.New("access denied")
+	}
+	return parseResultsPage(body)
+}
+
+// Parse the results table of the exam results page. Only exams with a
+// published grade are returned.
+func parseResultsPage(body string) ([]examResult, error) {
+	tables, err := parseHTMLTables(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		courseCol := table.column("veranstaltung", "kursname", "modul", "course")
+		gradeCol := table.column("note", "grade")
+		if courseCol < 0 || gradeCol < 0 {
+			continue
+		}
+		nameCol := table.column("prüfung", "exam")
+		if nameCol == courseCol {
+			nameCol = -1
+		}
+		codeCol := table.column("nr.", "nummer", "number")
+
+		results := []examResult{}
+		for _, row := range table.rows {
+			grade := strings.Join(cellAt(row, gradeCol).lines(), " ")
+			lines := cellAt(row, courseCol).lines()
+			if !gradePublished(grade) || len(lines) == 0 {
+				continue
+			}
+			r := examResult{Course: lines[0], Grade: grade}
+			if m := courseCodePattern.FindStringSubmatch(r.Course); m != nil {
+				r.Code = strings.ToLower(m[1])
+				r.Course = strings.TrimSpace(r.Course[len(m[0]):])
+			}
+			if m := courseCodePattern.FindStringSubmatch(cellAt(row, codeCol).text); m != nil {
+				r.Code = strings.ToLower(m[1])
+			}
+			if nameCol >= 0 {
+				r.Name = strings.Join(cellAt(row, nameCol).lines(), " ")
+			} else if len(lines) > 1 {
+				r.Name = strings.Join(lines[1:], " ")
+			}
+			results = append(results, r)
+		}
+		return results, nil
+	}
+	return nil, errors.New("no results table found")
+}
+
+// A grade is a number like "1,7" or a pass or fail, not a placeholder like
+// "noch nicht gesetzt"
+func gradePublished(grade string) bool {
+	lower := strings.ToLower(grade)
+	if strings.ContainsAny(lower, "0123456789") {
+		return true
+	}
+	for _, word := range []string{"bestanden", "passed", "failed"} {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Identify the exam without its grade, so the state doesn't hold grades
+func (r examResult) key() string {
+	sum := sha256.Sum256([]byte(r.Code + "|" + r.Course + "|" + r.Name))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Describe the result like "Analysis I (Klausur): 1,7", without the grade if
+// it isn't set
+func (r examResult) String() string {
+	text := r.Course
+	if r.Name != "" {
+		text += " (" + r.Name + ")"
+	}
+	if r.Grade != "" {
+		text += ": " + r.Grade
+	}
+	return text
+}
+
+// Return the results that weren't published at the last check and store
+// all of them. The first check only records the results, so grades published
+// before grades were enabled aren't announced.
+func (a *account) newGrades(results []examResult) ([]examResult, error) {
+	path := filepath.Join(a.dataDir, gradesFile)
+	var state struct {
+		Published []string `json:"published"`
+	}
+	data, err := os.ReadFile(path)
+	first := errors.Is(err, os.ErrNotExist)
+	if err != nil && !first {
+		return nil, err
+	}
+	if !first {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, err
+		}
+	}
+
+	var published []examResult
+	for _, r := range results {
+		if slices.Contains(state.Published, r.key()) {
+			continue
+		}
+		state.Published = append(state.Published, r.key())
+		if !first {
+			published = append(published, r)
+		}
+	}
+	if data, err = json.Marshal(state); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return nil, err
+	}
+	return published, nil
+}
+
+// Notify about the grades published since the last check
+func (a *account) notifyGrades(results []examResult) {
+	published, err := a.newGrades(results)
+	if err != nil {
+		a.log.Printf("Failed to check for new grades: %v", err)
+		return
+	}
+	for _, r := range published {
+		a.log.Printf("New grade published for %s", r.Course)
+		if !a.grades.ShowGrade {
+			r.Grade = ""
+		}
+		n := newNotification(notifyGrade, a.name)
+		n.Grade = &r
+		a.notify(n)
+	}
+}
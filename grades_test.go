@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingNotifier keeps the notifications it was sent
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []notification
+}
+
+func (r *recordingNotifier) notify(n notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func readResultsFixture(t *testing.T) []examResult {
+	t.Helper()
+	body, err := os.ReadFile("testdata/grades/examresults.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := parseResultsPage(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestParseResultsPage(t *testing.T) {
+	results := readResultsFixture(t)
+	// Lineare Algebra has no grade yet
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	want := examResult{Code: "01-11-0001", Course: "Analysis I", Name: "Fachprüfung", Grade: "1,7"}
+	if results[0] != want {
+		t.Errorf("got %+v, want %+v", results[0], want)
+	}
+	if results[1].Grade != "bestanden" {
+		t.Errorf("unexpected grade %q", results[1].Grade)
+	}
+	if _, err := parseResultsPage("<html><body><p>Keine Ergebnisse</p></body></html>"); err == nil {
+		t.Error("expected an error for a page without the results table")
+	}
+}
+
+func TestNotifyGrades(t *testing.T) {
+	results := readResultsFixture(t)
+	for _, showGrade := range []bool{false, true} {
+		acc := newAccount("alice", t.TempDir())
+		recorder := &recordingNotifier{}
+		acc.notifiers = []notifier{recorder}
+		acc.grades = &gradeConfig{ShowGrade: showGrade}
+
+		// The first check only records what is already published
+		acc.notifyGrades(results[1:])
+		acc.notifying.Wait()
+		if len(recorder.sent) != 0 {
+			t.Fatalf("expected no notifications for the first check, got %+v", recorder.sent)
+		}
+		acc.notifyGrades(results)
+		acc.notifyGrades(results)
+		acc.notifying.Wait()
+		if len(recorder.sent) != 1 || recorder.sent[0].Type != notifyGrade {
+			t.Fatalf("expected one grade notification, got %+v", recorder.sent)
+		}
+
+		n := recorder.sent[0]
+		title, text := notificationText(n)
+		if showGrade != strings.Contains(text, "1,7") || showGrade != strings.Contains(title, "1,7") || (n.Grade.Grade != "") != showGrade {
+			t.Errorf("show_grade %v: unexpected notification %q: %q", showGrade, title, text)
+		}
+		if want := "alice: Grade published: Analysis I (Fachprüfung)"; !strings.HasPrefix(title, want) {
+			t.Errorf("got %q, want %q", title, want)
+		}
+
+		// The stored state doesn't hold the grades
+		state, err := os.ReadFile(filepath.Join(acc.dataDir, gradesFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(state), "1,7") || strings.Contains(string(state), "Analysis") {
+			t.Errorf("the state holds the results: %s", state)
+		}
+	}
+}
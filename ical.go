@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -10,7 +11,6 @@ import (
 	"net/url"
 	"os"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/html"
@@ -18,79 +18,229 @@ import (
 	"golang.org/x/text/transform"
 )
 
-var lastNewestCalendarGetOK atomic.Bool
-
 var errNoEvents = errors.New("no events")
 var errInvalidCredentials = errors.New("incorrect username or password")
+var errNoCalendarData = errors.New("no calendar data")
+
+// loginError is returned by an update that failed to log in, as opposed to one
+// that failed to export or store the calendar
+type loginError struct {
+	err error
+}
 
-func startCalendarUpdater(username, password, totpSeed, totpID string, updateInterval time.Duration) {
-	ticker := time.NewTicker(updateInterval)
-	icals := make(map[string]string)
+func (e loginError) Error() string { return e.err.Error() }
+func (e loginError) Unwrap() error { return e.err }
+
+func startCalendarUpdater(acc *account) {
+	if err := os.MkdirAll(acc.dataDir, 0755); err != nil {
+		acc.log.Printf("Failed to create data directory %s: %v", acc.dataDir, err)
+		return
+	}
+	runCalendarUpdater(acc, acc.icalPath(), false)
+}
+
+// Fetch the calendar according to the account's schedule, or when a refresh
+// is requested, and write the merged result to out, or to stdout if out is
+// "-". With once set it returns after the first attempt.
+func runCalendarUpdater(acc *account, out string, once bool) error {
+	data := &tucanData{months: make(map[string]string), sources: make(map[string]string)}
 	consecutiveInvalidLogins := 0
+	loginFailing := false
+	consecutiveExportFailures := 0
 
-	defer ticker.Stop()
+	defer acc.refresh.stop()
+	// Let a single fetch deliver its notifications before the process exits
+	defer acc.flushNotifications()
 
 	for {
-		log.Println("Updating calendar...")
-
-		// Fetch iCalendar data
-		newIcals, err := fetchIcalData(username, password, totpSeed, totpID)
-		if err != nil {
-			if errors.Is(err, errInvalidCredentials) {
-				consecutiveInvalidLogins++
-				if consecutiveInvalidLogins >= 2 {
-					log.Printf("Login failed twice in a row with invalid credentials, exiting: %v", err)
-					os.Exit(1)
-				}
-			} else {
-				consecutiveInvalidLogins = 0
+		job := acc.refresh.start(acc.name)
+		if job.Trigger != "schedule" {
+			acc.log.Printf("Refresh %s requested by %s", job.ID, job.Trigger)
+		}
+		err := updateCalendar(acc, data, out)
+		acc.refresh.finish(job, err)
+
+		// Only notify when the login starts failing and when it works again
+		var loginErr loginError
+		if failed := errors.As(err, &loginErr); failed != loginFailing {
+			loginFailing = failed
+			n := newNotification(notifyLoginRecovered, acc.name)
+			if failed {
+				n = newNotification(notifyLoginFailed, acc.name)
+				n.Error = err.Error()
 			}
-
-			<-ticker.C
-			continue
+			acc.notify(n)
 		}
-		consecutiveInvalidLogins = 0
 
-		// Replace each month with the latest successful export.
-		for month, ics := range newIcals {
-			icals[month] = ics
+		// The login worked but the newest month couldn't be exported, like the health check
+		if !errors.As(err, &loginErr) && (err != nil || !acc.lastNewestCalendarGetOK.Load()) {
+			consecutiveExportFailures++
+			if consecutiveExportFailures == exportFailureAlertAfter {
+				n := newNotification(notifyExportFailed, acc.name)
+				n.Error = fmt.Sprintf("the calendar export failed %d times in a row", consecutiveExportFailures)
+				if err != nil {
+					n.Error += ": " + err.Error()
+				}
+				acc.notify(n)
+			}
+		} else if err == nil {
+			consecutiveExportFailures = 0
 		}
 
-		// Merge iCalendar data
-		var calendarValues []string
-		for _, ics := range icals {
-			calendarValues = append(calendarValues, ics)
+		if once {
+			return err
 		}
-		mergedCalendar := mergeIcs(calendarValues)
-
-		if len(calendarValues) > 0 {
-			if err := os.WriteFile(icalFile, []byte(mergedCalendar), 0644); err != nil {
-				log.Printf("Failed to write %s: %v", icalFile, err)
-			} else {
-				log.Println("Updated", icalFile)
+		if errors.Is(err, errInvalidCredentials) {
+			consecutiveInvalidLogins++
+			if consecutiveInvalidLogins >= 2 {
+				// Only stop this account, the others keep running
+				acc.log.Printf("Login failed twice in a row with invalid credentials, stopping updates: %v", err)
+				return err
 			}
 		} else {
-			log.Println("No calendar data to update")
+			consecutiveInvalidLogins = 0
 		}
 
-		<-ticker.C
+		next := acc.schedule.nextWithJitter(job.Started)
+		acc.log.Printf("Next update at %s", next.In(acc.schedule.loc).Format("2006-01-02 15:04:05 MST"))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-acc.refresh.trigger:
+			timer.Stop()
+		}
+	}
+}
+
+// tucanData is what was fetched from TUCaN. Sources tells whether a month
+// came from the export or the month view. Exams, deadlines and results are
+// nil if they weren't fetched.
+type tucanData struct {
+	months    map[string]string
+	sources   map[string]string
+	exams     []exam
+	deadlines []deadline
+	results   []examResult
+}
+
+// Fetch all months, the exams and the deadlines, replace each of them in data
+// with their latest successful export and write the merged calendar
+func updateCalendar(acc *account, data *tucanData, out string) error {
+	acc.log.Println("Updating calendar...")
+
+	// Fetch iCalendar data
+	fetched, err := fetchIcalData(acc)
+	if err != nil {
+		return err
 	}
+	return applyUpdate(acc, data, fetched, out)
 }
 
-func fetchIcalData(username, password, totpSeed, totpID string) (map[string]string, error) {
-	icals := make(map[string]string)
-	lastNewestCalendarGetOK.Store(false)
+// Merge what was fetched into data and write the calendar to out. Changes are
+// only recorded and notified for the account's own calendar, a one-shot fetch
+// to another file leaves its state alone.
+func applyUpdate(acc *account, data, fetched *tucanData, out string) error {
+	// Replace each month with the latest successful export.
+	for month, ics := range fetched.months {
+		data.months[month] = ics
+		data.sources[month] = fetched.sources[month]
+	}
+	acc.setMonthSources(data.sources)
+	if fetched.exams != nil {
+		data.exams = fetched.exams
+	}
+	if fetched.deadlines != nil {
+		data.deadlines = fetched.deadlines
+	}
+	if fetched.results != nil {
+		acc.notifyGrades(fetched.results)
+	}
+
+	// Merge iCalendar data
+	var calendarValues []string
+	for _, ics := range data.months {
+		calendarValues = append(calendarValues, ics)
+	}
+	if len(calendarValues) == 0 {
+		acc.log.Println("No calendar data to update")
+		return errNoCalendarData
+	}
+	if acc.exams != nil && len(data.exams) > 0 {
+		calendarValues = append(calendarValues, examsCalendar(data.exams, *acc.exams))
+	}
+	if acc.deadlines != nil && len(data.deadlines) > 0 {
+		calendarValues = append(calendarValues, deadlinesCalendar(data.deadlines, *acc.deadlines))
+	}
+	mergedCalendar := mergeIcs(calendarValues, acc.calendar.properties())
+	originalCalendar := mergedCalendar
+	if original, finished, err := acc.finishCalendar(mergedCalendar); err != nil {
+		acc.log.Printf("Failed to apply the calendar settings, writing it unchanged: %v", err)
+	} else {
+		originalCalendar, mergedCalendar = original, finished
+	}
+
+	// Keep the previous calendar to compare against. Both are compared before
+	// the rewrite rules, so editing a rule doesn't show up as changes.
+	stored := out == acc.icalPath()
+	var previous []byte
+	if stored {
+		previous, _ = os.ReadFile(acc.originalPath())
+	}
+
+	if err := writeCalendar(out, mergedCalendar); err != nil {
+		acc.log.Printf("Failed to write %s: %v", out, err)
+		return err
+	}
+	acc.log.Println("Updated", out)
+	if !stored {
+		return nil
+	}
+	if err := writeFileAtomic(acc.originalPath(), []byte(originalCalendar)); err != nil {
+		acc.log.Printf("Failed to write %s: %v", acc.originalPath(), err)
+	}
+	select {
+	case acc.calendarUpdated <- struct{}{}:
+	default:
+	}
+
+	months := make(map[string]bool)
+	for month := range data.months {
+		months[month] = true
+	}
+	set, err := acc.changes.record(acc.name, string(previous), originalCalendar, months, time.Now())
+	if err != nil {
+		acc.log.Printf("Failed to record changes: %v", err)
+	} else if set != nil {
+		acc.log.Printf("Calendar changed, %d change(s)", len(set.Changes))
+		n := newNotification(notifyChanges, acc.name)
+		n.Changes = set.Changes
+		acc.notify(n)
+	}
+	return nil
+}
+
+func writeCalendar(path, calendar string) error {
+	if path == "-" {
+		_, err := os.Stdout.WriteString(calendar)
+		return err
+	}
+	return os.WriteFile(path, []byte(calendar), 0644)
+}
+
+func fetchIcalData(acc *account) (*tucanData, error) {
+	fetched := &tucanData{months: make(map[string]string), sources: make(map[string]string)}
+	acc.lastNewestCalendarGetOK.Store(false)
 
 	// Create a new client with a cookie jar
 	jar, _ := cookiejar.New(nil)
 	client := &http.Client{Jar: jar}
 
 	// Log in with the client and get the session cookie
-	session, err := login(client, username, password, totpSeed, totpID)
+	session, err := login(client, acc.username.get(), acc.password.get(), acc.totpSeed.get(), acc.totpID.get())
 	if err != nil {
-		log.Printf("Login failed: %v", err)
-		lastNewestCalendarGetOK.Store(false)
-		return icals, err
+		acc.log.Printf("Login failed: %v", err)
+		acc.lastNewestCalendarGetOK.Store(false)
+		return fetched, loginError{err}
 	}
 
 	const newestMonthOffset = 7
@@ -111,32 +261,66 @@ func fetchIcalData(username, password, totpSeed, totpID string) (map[string]stri
 
 		// Get the iCalendar file
 		ics, err := getIcalendar(client, form)
-		if err != nil {
-			if i == newestMonthOffset {
-				lastNewestCalendarGetOK.Store(errors.Is(err, errNoEvents))
-			}
-			log.Printf("Error getting iCalendar for %s: %v", month, err)
-			continue
+		if i == newestMonthOffset {
+			acc.lastNewestCalendarGetOK.Store(ics != "" || errors.Is(err, errNoEvents))
 		}
-		if ics == "" {
-			if i == newestMonthOffset {
-				lastNewestCalendarGetOK.Store(false)
+		source := sourceExport
+		if (err != nil && !errors.Is(err, errNoEvents)) || (err == nil && ics == "") {
+			if err != nil {
+				acc.log.Printf("Error getting iCalendar for %s: %v", month, err)
+			} else {
+				acc.log.Printf("No iCalendar data for %s", month)
 			}
-			log.Printf("No iCalendar data for %s", month)
-			continue
+			// Read the month off the scheduler's month view instead
+			ics, err = fetchMonthView(client, session, month)
+			if err != nil {
+				acc.log.Printf("Error getting the month view for %s: %v", month, err)
+				continue
+			}
+			source = sourceMonthView
 		}
-		if i == newestMonthOffset {
-			lastNewestCalendarGetOK.Store(true)
+		if err != nil {
+			acc.log.Printf("Error getting iCalendar for %s: %v", month, err)
+			continue
 		}
 
 		event_count := countEvents(ics)
-		log.Printf("Got iCalendar for %s with %d events", month, event_count)
+		acc.log.Printf("Got iCalendar for %s with %d events from the %s", month, event_count, source)
 
 		// Store the iCalendar data in the map
-		icals[month] = ics
+		fetched.months[month] = ics
+		fetched.sources[month] = source
 	}
 
-	return icals, nil
+	// The exams are on their own page, reached with the same session
+	if acc.exams != nil {
+		exams, err := fetchExams(client, session)
+		if err != nil {
+			acc.log.Printf("Error getting the exams: %v", err)
+		} else {
+			acc.log.Printf("Got %d exams", len(exams))
+			fetched.exams = exams
+		}
+	}
+	if acc.deadlines != nil {
+		deadlines, err := acc.fetchDeadlines(client, session)
+		if err != nil {
+			acc.log.Printf("Error getting the registration deadlines: %v", err)
+		} else {
+			acc.log.Printf("Got %d registration deadlines", len(deadlines))
+			fetched.deadlines = deadlines
+		}
+	}
+	if acc.grades != nil {
+		results, err := fetchResults(client, session)
+		if err != nil {
+			acc.log.Printf("Error getting the exam results: %v", err)
+		} else {
+			fetched.results = results
+		}
+	}
+
+	return fetched, nil
 }
 
 func getIcalendar(client *http.Client, values url.Values) (string, error) {
@@ -220,22 +404,63 @@ func extractFiletransferLink(htmlStr string) string {
 	return ""
 }
 
-// dumb concat of .ics files skipping BEGIN:VCALENDAR/END:VCALENDAR
-func mergeIcs(calendars []string) string {
+// Merge the monthly exports into one calendar described by header. The
+// calendar properties of the exports, like their PRODID, are dropped.
+func mergeIcs(calendars []string, header []string) string {
 	var merged bytes.Buffer
-	merged.WriteString("BEGIN:VCALENDAR\n")
+	merged.WriteString("BEGIN:VCALENDAR\r\n")
+	for _, line := range header {
+		merged.WriteString(line + "\r\n")
+	}
 	for _, ics := range calendars {
-		lines := strings.Split(ics, "\n")
-		for _, line := range lines {
-			if !strings.HasPrefix(line, "BEGIN:VCALENDAR") && !strings.HasPrefix(line, "END:VCALENDAR") {
-				merged.WriteString(line + "\n")
+		depth := 0
+		skipping := false
+		for _, line := range strings.Split(ics, "\n") {
+			// Folded continuation lines belong to the previous line
+			if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+				if !skipping {
+					merged.WriteString(line + "\n")
+				}
+				continue
+			}
+			// Lines directly inside VCALENDAR are its properties
+			switch {
+			case strings.HasPrefix(line, "BEGIN:"):
+				depth++
+				skipping = depth <= 1
+			case strings.HasPrefix(line, "END:"):
+				skipping = depth <= 1
+				depth--
+			default:
+				skipping = depth <= 1
 			}
+			if skipping || strings.TrimSpace(line) == "" {
+				continue
+			}
+			merged.WriteString(line + "\n")
 		}
 	}
-	merged.WriteString("END:VCALENDAR\n")
+	merged.WriteString("END:VCALENDAR\r\n")
 	return merged.String()
 }
 
+// Apply the account's settings to the merged calendar before it is written:
+// normalize the times, add the course information, rewrite the events and
+// add the alarms. It also returns the calendar before the rewrite rules and
+// alarms.
+func (a *account) finishCalendar(merged string) (string, string, error) {
+	cal, err := parseICalendar(merged)
+	if err != nil {
+		return "", "", err
+	}
+	normalizeTimes(cal, a.calendar.Times == timesUTC)
+	annotateCourses(cal)
+	original := cal.serialize()
+	rewriteEvents(cal, a.rewrites)
+	addAlarms(cal, a.alarms)
+	return original, cal.serialize(), nil
+}
+
 func utf16ToUTF8(utf16 []byte) ([]byte, error) {
 	decoder := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
 	reader := transform.NewReader(bytes.NewReader(utf16), decoder)
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// icalComponent is a parsed iCalendar component like VCALENDAR, VEVENT or VTIMEZONE
+type icalComponent struct {
+	name       string
+	properties []*icalProperty
+	components []*icalComponent
+}
+
+// icalProperty is a single content line, e.g. DTSTART;TZID=Europe/Berlin:20250101T081500
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+	line   int
+}
+
+// Parse an iCalendar document. Folded lines are joined and both CRLF and LF
+// line endings are accepted. Errors include the line number.
+func parseICalendar(data string) (*icalComponent, error) {
+	var stack []*icalComponent
+	var root *icalComponent
+
+	for _, line := range unfoldLines(data) {
+		if strings.TrimSpace(line.text) == "" {
+			continue
+		}
+		prop, err := parseContentLine(line.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.number, err)
+		}
+		prop.line = line.number
+
+		switch prop.name {
+		case "BEGIN":
+			component := &icalComponent{name: strings.ToUpper(prop.value)}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, fmt.Errorf("line %d: more than one top-level component", line.number)
+				}
+				root = component
+			} else {
+				parent := stack[len(stack)-1]
+				parent.components = append(parent.components, component)
+			}
+			stack = append(stack, component)
+		case "END":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: END:%s without BEGIN", line.number, prop.value)
+			}
+			current := stack[len(stack)-1]
+			if !strings.EqualFold(current.name, prop.value) {
+				return nil, fmt.Errorf("line %d: END:%s does not match BEGIN:%s", line.number, prop.value, current.name)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: property %s outside of a component", line.number, prop.name)
+			}
+			current := stack[len(stack)-1]
+			current.properties = append(current.properties, prop)
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("BEGIN:%s is never closed", stack[len(stack)-1].name)
+	}
+	if root == nil {
+		return nil, errors.New("no calendar data")
+	}
+	return root, nil
+}
+
+type unfoldedLine struct {
+	text   string
+	number int
+}
+
+// Join folded lines, a line starting with a space or tab continues the previous one
+func unfoldLines(data string) []unfoldedLine {
+	var lines []unfoldedLine
+	for i, raw := range strings.Split(data, "\n") {
+		raw = strings.TrimSuffix(raw, "\r")
+		if len(lines) > 0 && (strings.HasPrefix(raw, " ") || strings.HasPrefix(raw, "\t")) {
+			lines[len(lines)-1].text += raw[1:]
+			continue
+		}
+		lines = append(lines, unfoldedLine{text: raw, number: i + 1})
+	}
+	return lines
+}
+
+// Split a content line into name, parameters and value. Parameter values may
+// be quoted and contain ':' or ';'.
+func parseContentLine(line string) (*icalProperty, error) {
+	prop := &icalProperty{params: make(map[string]string)}
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return nil, fmt.Errorf("malformed content line %q", line)
+	}
+	prop.name = strings.ToUpper(line[:i])
+
+	for line[i] == ';' {
+		rest := line[i+1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("malformed parameter in %q", line)
+		}
+		name := strings.ToUpper(rest[:eq])
+		j := i + 1 + eq + 1
+
+		var value strings.Builder
+		quoted := false
+		for ; j < len(line); j++ {
+			c := line[j]
+			if c == '"' {
+				quoted = !quoted
+				continue
+			}
+			if !quoted && (c == ';' || c == ':') {
+				break
+			}
+			value.WriteByte(c)
+		}
+		if j >= len(line) {
+			return nil, fmt.Errorf("missing value in %q", line)
+		}
+		prop.params[name] = value.String()
+		i = j
+	}
+
+	prop.value = line[i+1:]
+	return prop, nil
+}
+
+// Return the first property with the given name or nil
+func (c *icalComponent) property(name string) *icalProperty {
+	for _, prop := range c.properties {
+		if prop.name == name {
+			return prop
+		}
+	}
+	return nil
+}
+
+// Return the value of the first property with the given name
+func (c *icalComponent) value(name string) string {
+	if prop := c.property(name); prop != nil {
+		return prop.value
+	}
+	return ""
+}
+
+// Replace the first property with the given name, or add it if there is none
+func (c *icalComponent) set(name string, params map[string]string, value string) {
+	if params == nil {
+		params = make(map[string]string)
+	}
+	if prop := c.property(name); prop != nil {
+		prop.params = params
+		prop.value = value
+		return
+	}
+	c.properties = append(c.properties, &icalProperty{name: name, params: params, value: value})
+}
+
+// Return all direct child components with the given name
+func (c *icalComponent) children(name string) []*icalComponent {
+	var children []*icalComponent
+	for _, child := range c.components {
+		if child.name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// Write the component back in iCalendar format with CRLF line endings and
+// lines folded at 75 octets
+func (c *icalComponent) serialize() string {
+	var b strings.Builder
+	c.writeTo(&b)
+	return b.String()
+}
+
+func (c *icalComponent) writeTo(b *strings.Builder) {
+	writeFolded(b, "BEGIN:"+c.name)
+	for _, prop := range c.properties {
+		writeFolded(b, prop.String())
+	}
+	for _, child := range c.components {
+		child.writeTo(b)
+	}
+	writeFolded(b, "END:"+c.name)
+}
+
+func (p *icalProperty) String() string {
+	var b strings.Builder
+	b.WriteString(p.name)
+
+	names := make([]string, 0, len(p.params))
+	for name := range p.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := p.params[name]
+		if strings.ContainsAny(value, ";:,") {
+			value = `"` + value + `"`
+		}
+		b.WriteString(";" + name + "=" + value)
+	}
+
+	b.WriteString(":" + p.value)
+	return b.String()
+}
+
+func writeFolded(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		// Don't split a multi-byte UTF-8 character
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space which counts towards the limit
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// Escape a TEXT value, e.g. "Analysis I, Übung" into "Analysis I\, Übung"
+func escapeText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
+
+// Decode an escaped TEXT value, e.g. "S1|01 A1\, Hörsaal" into "S1|01 A1, Hörsaal"
+func unescapeText(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+			switch value[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(value[i])
+			}
+			continue
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// Parse a DATE or DATE-TIME value. Times with a TZID are interpreted in that
+// zone, UTC times end with Z and floating times are returned in loc.
+func parseICalTime(prop *icalProperty, loc *time.Location) (time.Time, error) {
+	if prop == nil {
+		return time.Time{}, errors.New("missing date")
+	}
+	if tzid := prop.params["TZID"]; tzid != "" {
+		zone, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown TZID %q", tzid)
+		}
+		loc = zone
+	}
+
+	value := prop.value
+	switch {
+	case prop.params["VALUE"] == "DATE" || len(value) == 8:
+		return time.ParseInLocation("20060102", value, loc)
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	default:
+		return time.ParseInLocation("20060102T150405", value, loc)
+	}
+}
+
+// Check a parsed calendar for problems calendar clients commonly trip over.
+// It returns the number of events and a description of every problem found.
+func validateICalendar(cal *icalComponent) (int, []string) {
+	var problems []string
+	if cal.name != "VCALENDAR" {
+		return 0, []string{fmt.Sprintf("top-level component is %s, expected VCALENDAR", cal.name)}
+	}
+
+	uids := make(map[string]int)
+	events := cal.children("VEVENT")
+	for _, event := range events {
+		where := "VEVENT"
+		if summary := event.value("SUMMARY"); summary != "" {
+			where = fmt.Sprintf("VEVENT %q", summary)
+		}
+		if dtstart := event.property("DTSTART"); dtstart != nil {
+			where = fmt.Sprintf("%s (line %d)", where, dtstart.line)
+		}
+
+		uid := event.value("UID")
+		if uid == "" {
+			problems = append(problems, where+": missing UID")
+		} else if uids[uid]++; uids[uid] == 2 {
+			problems = append(problems, fmt.Sprintf("%s: duplicate UID %q", where, uid))
+		}
+
+		start, err := parseICalTime(event.property("DTSTART"), time.UTC)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid DTSTART: %v", where, err))
+			continue
+		}
+		if dtend := event.property("DTEND"); dtend != nil {
+			end, err := parseICalTime(dtend, time.UTC)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid DTEND: %v", where, err))
+			} else if end.Before(start) {
+				problems = append(problems, where+": DTEND is before DTSTART")
+			}
+		}
+	}
+	return len(events), problems
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:event-1\r\n" +
+	"SUMMARY:20-00-0005-iv Grundlagen der Informatik\r\n" +
+	"  mit einer sehr langen Zeile\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20251027T081500\r\n" +
+	"DTEND;TZID=Europe/Berlin:20251027T095500\r\n" +
+	"LOCATION;ALTREP=\"https://example.org/a;b\":S101/A1\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalendar(t *testing.T) {
+	cal, err := parseICalendar(testCalendar)
+	if err != nil {
+		t.Fatalf("parseICalendar failed: %v", err)
+	}
+
+	events := cal.children("VEVENT")
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if got := event.value("SUMMARY"); got != "20-00-0005-iv Grundlagen der Informatik mit einer sehr langen Zeile" {
+		t.Fatalf("folded SUMMARY not joined: %q", got)
+	}
+	location := event.property("LOCATION")
+	if location.value != "S101/A1" || location.params["ALTREP"] != "https://example.org/a;b" {
+		t.Fatalf("unexpected LOCATION: %+v", location)
+	}
+
+	start, err := parseICalTime(event.property("DTSTART"), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(time.Date(2025, 10, 27, 7, 15, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected DTSTART %v", start)
+	}
+}
+
+func TestSerializeICalendarRoundTrip(t *testing.T) {
+	cal, err := parseICalendar(testCalendar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := cal.serialize()
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line longer than 75 octets: %q", line)
+		}
+	}
+
+	again, err := parseICalendar(out)
+	if err != nil {
+		t.Fatalf("serialized calendar does not parse: %v\n%s", err, out)
+	}
+	if again.serialize() != out {
+		t.Fatalf("round trip changed the calendar:\n%s\n%s", out, again.serialize())
+	}
+}
+
+func TestParseICalendarErrors(t *testing.T) {
+	tests := map[string]string{
+		"unclosed":   "BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VCALENDAR\n",
+		"unopened":   "END:VEVENT\n",
+		"no colon":   "BEGIN:VCALENDAR\nSUMMARY\nEND:VCALENDAR\n",
+		"empty file": "\r\n",
+	}
+	for name, data := range tests {
+		if _, err := parseICalendar(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestValidateICalendar(t *testing.T) {
+	cal, err := parseICalendar("BEGIN:VCALENDAR\n" +
+		"BEGIN:VEVENT\nUID:a\nDTSTART:20251027T081500Z\nDTEND:20251027T071500Z\nEND:VEVENT\n" +
+		"BEGIN:VEVENT\nUID:a\nDTSTART:20251027T081500Z\nEND:VEVENT\n" +
+		"BEGIN:VEVENT\nDTSTART:tomorrow\nEND:VEVENT\n" +
+		"END:VCALENDAR\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, problems := validateICalendar(cal)
+	if events != 3 {
+		t.Fatalf("expected 3 events, got %d", events)
+	}
+	all := strings.Join(problems, "\n")
+	for _, want := range []string{"DTEND is before DTSTART", `duplicate UID "a"`, "missing UID", "invalid DTSTART"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("expected a problem mentioning %q, got:\n%s", want, all)
+		}
+	}
+}
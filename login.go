@@ -3,15 +3,18 @@ package main
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base32"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
 	"html"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -20,14 +23,44 @@ import (
 
 const tucanAuthorizeURL = "https://dsf.tucan.tu-darmstadt.de/IdentityServer/connect/authorize?client_id=ClassicWeb&scope=openid%20DSF%20email&response_mode=query&response_type=code&ui_locales=de&redirect_uri=https%3a%2f%2fwww.tucan.tu-darmstadt.de%2Fscripts%2Fmgrqispi.dll%3FAPPNAME%3DCampusNet%26PRGNAME%3DLOGINCHECK%26ARGUMENTS%3D-N000000000000001%2Cids_mode%26ids_mode%3DY"
 
+// debugLogin logs every request and response during login, set from the config
+var debugLogin bool
+
+// loginTrace is notified about the progress of a login, used by the
+// login-check and tokens commands. All callbacks are optional.
+type loginTrace struct {
+	stage  func(name string)
+	tokens func(tokens map[string]string)
+	// Return without an error or session once the token selection page
+	// was reported, instead of selecting a token
+	stopAtTokens bool
+}
+
+func (t *loginTrace) reached(name string) {
+	if t != nil && t.stage != nil {
+		t.stage(name)
+	}
+}
+
+func (t *loginTrace) foundTokens(tokens map[string]string) {
+	if t != nil && t.tokens != nil {
+		t.tokens(tokens)
+	}
+}
+
 func login(client *http.Client, username, password, totpSeed, totpID string) (string, error) {
-	debug := strings.EqualFold(os.Getenv("DEBUG_LOGIN"), "true")
+	return loginWithTrace(client, username, password, totpSeed, totpID, nil)
+}
+
+func loginWithTrace(client *http.Client, username, password, totpSeed, totpID string, trace *loginTrace) (string, error) {
+	debug := debugLogin
 	manualClient := cloneClientNoRedirect(client)
 
 	resp, body, err := doRequestAndFollowRedirects(manualClient, "GET", tucanAuthorizeURL, "", debug)
 	if err != nil {
 		return "", err
 	}
+	trace.reached("opened the TUCaN login page")
 
 	// Check if this is the TU-ID DFN Shibboleth SSO page
 	if strings.Contains(body, "provider=dfnshib") {
@@ -42,6 +75,7 @@ func login(client *http.Client, username, password, totpSeed, totpID string) (st
 			if err != nil {
 				return "", err
 			}
+			trace.reached("followed the TU-ID SSO link")
 		} else {
 			return "", errorsWithBody("TU-ID SSO link not found", body)
 		}
@@ -80,6 +114,7 @@ func login(client *http.Client, username, password, totpSeed, totpID string) (st
 	if invalidCredentialsBody(body) {
 		return "", errInvalidCredentials
 	}
+	trace.reached("username and password accepted")
 
 	if !hasSAMLForm(body) && !isSelectTokenPage(body) {
 		totpField := detectTotpField(body)
@@ -87,6 +122,7 @@ func login(client *http.Client, username, password, totpSeed, totpID string) (st
 		if err != nil {
 			return "", err
 		}
+		trace.reached("submitted the OTP in field " + totpField)
 	}
 
 	if isSelectTokenPage(body) {
@@ -104,6 +140,10 @@ func login(client *http.Client, username, password, totpSeed, totpID string) (st
 		if len(tokens) == 0 {
 			return "", errorsWithBody("no token options found on token selection page", body)
 		}
+		trace.foundTokens(tokens)
+		if trace != nil && trace.stopAtTokens {
+			return "", nil
+		}
 
 		desiredID := chooseTokenID(tokens, totpID)
 		if _, ok := tokens[desiredID]; !ok {
@@ -127,6 +167,7 @@ func login(client *http.Client, username, password, totpSeed, totpID string) (st
 		if err != nil {
 			return "", err
 		}
+		trace.reached(fmt.Sprintf("selected token %q", desiredID))
 	}
 
 	// After token selection, we may land on OTP entry page (fudis_otp_input)
@@ -138,6 +179,10 @@ func login(client *http.Client, username, password, totpSeed, totpID string) (st
 		if err != nil {
 			return "", err
 		}
+		if invalidOTPBody(body) {
+			return "", errorsWithBody("OTP rejected, check TUCAN_TOTP and the system clock", body)
+		}
+		trace.reached("OTP accepted")
 	}
 
 	if hasSAMLForm(body) {
@@ -162,12 +207,14 @@ func login(client *http.Client, username, password, totpSeed, totpID string) (st
 		if err != nil {
 			return "", err
 		}
+		trace.reached("completed the SAML handover")
 	}
 
 	sessionID := extractSessionIDFromLoginResult(client, resp, body)
 	if sessionID == "" {
 		return "", errorsWithBody("no session ID found after login", body)
 	}
+	trace.reached("got a CampusNet session")
 
 	return sessionID, nil
 }
@@ -354,11 +401,16 @@ func submitOTP(client *http.Client, resp *http.Response, body, totpSeed, field s
 }
 
 func otpCandidates(now time.Time, totpSeed string) []string {
+	params, err := parseTOTP(totpSeed)
+	if err != nil {
+		return nil
+	}
+
 	var candidates []string
 	seen := make(map[string]bool)
-	for _, ts := range []time.Time{now, now.Add(-30 * time.Second), now.Add(30 * time.Second)} {
-		code := calculate_totp(totpSeed, ts)
-		if code != "" && !seen[code] {
+	for _, ts := range []time.Time{now, now.Add(-params.period), now.Add(params.period)} {
+		code := params.code(ts)
+		if !seen[code] {
 			seen[code] = true
 			candidates = append(candidates, code)
 		}
@@ -496,19 +548,80 @@ func extractCookieValueForURL(client *http.Client, u *url.URL, name string) stri
 	return ""
 }
 
-func calculate_totp(staticCode string, now time.Time) string {
-	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(staticCode), " ", ""))
-	decoder := base32.StdEncoding.WithPadding(base32.NoPadding)
-	secret, err := decoder.DecodeString(normalized)
-	if err != nil || len(secret) == 0 {
-		return ""
+// totpParams are the settings needed to generate codes, as described by an
+// otpauth:// URI. A bare base32 seed uses the defaults SHA1, 6 digits and 30s.
+type totpParams struct {
+	secret    []byte
+	algorithm string
+	digits    int
+	period    time.Duration
+}
+
+var totpAlgorithms = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// Parse TUCAN_TOTP, either a bare base32 seed or an otpauth://totp/ URI as
+// exported from authenticator apps
+func parseTOTP(value string) (totpParams, error) {
+	params := totpParams{algorithm: "SHA1", digits: 6, period: 30 * time.Second}
+	value = strings.TrimSpace(value)
+
+	if !strings.HasPrefix(strings.ToLower(value), "otpauth:") {
+		secret, err := decodeTOTPSecret(value)
+		if err != nil {
+			return params, err
+		}
+		params.secret = secret
+		return params, nil
 	}
 
-	counter := uint64(now.Unix() / 30)
+	u, err := url.Parse(value)
+	if err != nil {
+		return params, fmt.Errorf("malformed otpauth URI: %w", err)
+	}
+	if !strings.EqualFold(u.Host, "totp") {
+		return params, fmt.Errorf("unsupported otpauth type %q, only totp is supported", u.Host)
+	}
+
+	query := u.Query()
+	secret, err := decodeTOTPSecret(query.Get("secret"))
+	if err != nil {
+		return params, err
+	}
+	params.secret = secret
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		params.algorithm = strings.ToUpper(algorithm)
+		if _, ok := totpAlgorithms[params.algorithm]; !ok {
+			return params, fmt.Errorf("unsupported TOTP algorithm %q, use SHA1, SHA256 or SHA512", algorithm)
+		}
+	}
+	if digits := query.Get("digits"); digits != "" {
+		params.digits, err = strconv.Atoi(digits)
+		if err != nil || params.digits < 6 || params.digits > 10 {
+			return params, fmt.Errorf("invalid TOTP digits %q, must be between 6 and 10", digits)
+		}
+	}
+	if period := query.Get("period"); period != "" {
+		seconds, err := strconv.Atoi(period)
+		if err != nil || seconds <= 0 {
+			return params, fmt.Errorf("invalid TOTP period %q, must be a positive number of seconds", period)
+		}
+		params.period = time.Duration(seconds) * time.Second
+	}
+	return params, nil
+}
+
+// Generate the code for the time step containing now (RFC 6238)
+func (p totpParams) code(now time.Time) string {
+	counter := uint64(now.Unix() / int64(p.period/time.Second))
 	var counterBytes [8]byte
 	binary.BigEndian.PutUint64(counterBytes[:], counter)
 
-	mac := hmac.New(sha1.New, secret)
+	mac := hmac.New(totpAlgorithms[p.algorithm], p.secret)
 	mac.Write(counterBytes[:])
 	hash := mac.Sum(nil)
 
@@ -518,10 +631,37 @@ func calculate_totp(staticCode string, now time.Time) string {
 		(int(hash[offset+2])&0xff)<<8 |
 		(int(hash[offset+3]) & 0xff)
 
-	code := binaryCode % 1000000
+	modulus := 1
+	for i := 0; i < p.digits; i++ {
+		modulus *= 10
+	}
+	code := binaryCode % modulus
 	result := strconv.Itoa(code)
-	for len(result) < 6 {
+	for len(result) < p.digits {
 		result = "0" + result
 	}
 	return result
 }
+
+func calculate_totp(staticCode string, now time.Time) string {
+	params, err := parseTOTP(staticCode)
+	if err != nil {
+		return ""
+	}
+	return params.code(now)
+}
+
+// Decode a base32 TOTP seed the same way authenticator apps do, ignoring
+// spaces, case and padding
+func decodeTOTPSecret(seed string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(seed), " ", ""))
+	normalized = strings.TrimRight(normalized, "=")
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalized)
+	if err != nil {
+		return nil, errors.New("malformed base32 TOTP secret")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("empty TOTP secret")
+	}
+	return secret, nil
+}
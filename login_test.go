@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/base32"
 	"net/http"
 	"net/http/cookiejar"
 	"os"
@@ -197,3 +198,97 @@ func TestOtpCandidatesDeduplicatesWindowValues(t *testing.T) {
 		seen[code] = true
 	}
 }
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	// Reference values from RFC 6238 Appendix B, using the ASCII seeds given there
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		unix      int64
+		algorithm string
+		want      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tt := range tests {
+		secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(seeds[tt.algorithm]))
+		uri := "otpauth://totp/TUCaN:ab12cdef?secret=" + secret + "&algorithm=" + tt.algorithm + "&digits=8&period=30&issuer=TUCaN"
+
+		got := calculate_totp(uri, time.Unix(tt.unix, 0))
+		if got != tt.want {
+			t.Errorf("%s at %d: expected %s, got %s", tt.algorithm, tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestParseTOTP(t *testing.T) {
+	params, err := parseTOTP("otpauth://totp/TUCaN:ab12cdef?secret=JBSWY3DPEHPK3PXP&algorithm=sha256&digits=8&period=60")
+	if err != nil {
+		t.Fatalf("parseTOTP failed: %v", err)
+	}
+	if params.algorithm != "SHA256" || params.digits != 8 || params.period != time.Minute {
+		t.Fatalf("unexpected params: %+v", params)
+	}
+
+	// A bare seed and a URI with default parameters produce the same codes
+	bare := calculate_totp("JBSWY3DPEHPK3PXP", time.Unix(0, 0))
+	uri := calculate_totp("otpauth://totp/TUCaN?secret=JBSWY3DPEHPK3PXP", time.Unix(0, 0))
+	if bare != "282760" || uri != bare {
+		t.Fatalf("expected bare seed and URI to match 282760, got %s and %s", bare, uri)
+	}
+
+	invalid := []string{
+		"otpauth://hotp/TUCaN?secret=JBSWY3DPEHPK3PXP&counter=1",
+		"otpauth://totp/TUCaN?secret=not-base32!",
+		"otpauth://totp/TUCaN",
+		"otpauth://totp/TUCaN?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/TUCaN?secret=JBSWY3DPEHPK3PXP&digits=4",
+		"otpauth://totp/TUCaN?secret=JBSWY3DPEHPK3PXP&period=0",
+	}
+	for _, value := range invalid {
+		if _, err := parseTOTP(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestOtpCandidatesUsePeriod(t *testing.T) {
+	seed := "otpauth://totp/TUCaN?secret=JBSWY3DPEHPK3PXP&period=60"
+	now := time.Unix(120, 0)
+	got := otpCandidates(now, seed)
+
+	want := []string{
+		calculate_totp(seed, now),
+		calculate_totp(seed, now.Add(-time.Minute)),
+		calculate_totp(seed, now.Add(time.Minute)),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
-	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,6 +14,11 @@ const (
 	userAgent   = "TUCaN iCalendar Extractor/1.0"
 
 	icalFile = "merged_calendar.ics"
+	// The calendar before the rewrite rules and alarms, which changes are
+	// detected and rules are previewed on
+	originalFile = "original_calendar.ics"
+
+	minFeedTokenLength = 16
 )
 
 func main() {
@@ -23,36 +28,21 @@ func main() {
 		log.Println("Warning: .env file not found, proceeding without it")
 	}
 
-	// Get the server port from the environment variable, default to 8080
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	go runWebServer(port)
-
-	// Pull username, password, static TOTP seed, and TOTP ID from environment variables
-	username := os.Getenv("TUCAN_USERNAME")
-	password := os.Getenv("TUCAN_PASSWORD")
-	totpSeed := os.Getenv("TUCAN_TOTP")
-	totpID := os.Getenv("TUCAN_TOTP_ID")
-	if username == "" || password == "" || totpSeed == "" || totpID == "" {
-		log.Fatal("Please set TUCAN_USERNAME, TUCAN_PASSWORD, TUCAN_TOTP and TUCAN_TOTP_ID environment variables")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file, defaults to $CONFIG_FILE")
+	printConfig := flag.Bool("print-config", false, "print the effective config with secrets masked and exit, like the config command")
+	flag.Usage = usage
+	flag.Parse()
+
+	// Without a command, start the server like before subcommands existed
+	name := "serve"
+	args := flag.Args()
+	if *printConfig {
+		name = "config"
+	} else if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
 
-	// Get the update interval from the environment variable, default to 2 hours
-	intervalStr := os.Getenv("UPDATE_INTERVAL")
-	interval := 2 * time.Hour
-	if intervalStr != "" {
-		parsedInterval, err := time.ParseDuration(intervalStr)
-		if err != nil {
-			log.Printf("Invalid UPDATE_INTERVAL format, using default 2h: %v", err)
-		} else {
-			interval = parsedInterval
-		}
+	if err := runCommand(name, *configPath, args); err != nil {
+		log.Fatal(err)
 	}
-
-	// Fetch the iCalendar data
-	go startCalendarUpdater(username, password, totpSeed, totpID, interval)
-
-	select {}
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Where the events of a month came from
+const (
+	sourceExport    = "export"
+	sourceMonthView = "month view"
+)
+
+// monthSource is the source of a month of the calendar
+type monthSource struct {
+	Month  string `json:"month"`
+	Source string `json:"source"`
+}
+
+// scheduledEvent is an appointment of the scheduler's month view
+type scheduledEvent struct {
+	Summary  string
+	Location string
+	Start    time.Time
+	End      time.Time
+}
+
+func monthViewURL(session string, month time.Time) string {
+	return loginScript + "?APPNAME=CampusNet&PRGNAME=MONTH&ARGUMENTS=-N" + session + ",-N000271,-A" + month.Format("02.01.2006") + ",-A,-N1"
+}
+
+// Fetch the month view of the scheduler for month ("2006-01") and return its
+// appointments as a calendar, for months the export fails for
+func fetchMonthView(client *http.Client, session, month string) (string, error) {
+	first, err := time.ParseInLocation("2006-01", month, berlin)
+	if err != nil {
+		return "", err
+	}
+	_, body, err := doRequest(client, "GET", monthViewURL(session, first), "", debugLogin)
+	if err != nil {
+		return "", err
+	}
+	if accessDenied(body) {
+		return "", errors.New("access denied")
+	}
+	events, err := parseMonthView(body, first)
+	if err != nil {
+		return "", err
+	}
+	return monthViewCalendar(events), nil
+}
+
+// Parse the appointments of the month view starting at first. Every day is a
+// cell whose title holds its date, or else the day's number, and every
+// appointment a link titled like "08:15 - 09:55 / S1|01/A1 / Analysis I".
+// Days of the neighboring months shown around it are left out, their own
+// month view has them.
+func parseMonthView(body string, first time.Time) ([]scheduledEvent, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	next := first.AddDate(0, 1, 0)
+	found := false
+	events := []scheduledEvent{}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "table" && strings.Contains(attribute(n, "class"), "calendar") {
+			found = true
+		}
+		if n.Type == html.ElementNode && n.Data == "td" && strings.Contains(attribute(n, "class"), "tbMonthDay") {
+			day, ok := monthViewDay(n, first)
+			if ok && !day.Before(first) && day.Before(next) {
+				events = append(events, monthViewAppointments(n, day)...)
+			}
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	if !found {
+		return nil, errors.New("no month view found")
+	}
+	return events, nil
+}
+
+// Return the date of a day cell, from its title or from its number in the
+// month of first
+func monthViewDay(cell *html.Node, first time.Time) (time.Time, bool) {
+	if start, _, _, err := parseTucanTime(attribute(cell, "title"), first.Location()); err == nil {
+		return start, true
+	}
+	if strings.Contains(strings.ToLower(attribute(cell, "class")), "other") {
+		return time.Time{}, false
+	}
+	fields := strings.Fields(parseHTMLCell(cell).text)
+	if len(fields) == 0 {
+		return time.Time{}, false
+	}
+	number, err := strconv.Atoi(strings.TrimSuffix(fields[0], "."))
+	if err != nil || number < 1 || number > 31 {
+		return time.Time{}, false
+	}
+	return time.Date(first.Year(), first.Month(), number, 0, 0, 0, 0, first.Location()), true
+}
+
+// Return the appointments linked in a day cell
+func monthViewAppointments(cell *html.Node, day time.Time) []scheduledEvent {
+	var events []scheduledEvent
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			text := attribute(n, "title")
+			if text == "" {
+				text = strings.Join(parseHTMLCell(n).lines(), " / ")
+			}
+			if event, ok := parseAppointment(text, day); ok {
+				events = append(events, event)
+			}
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(cell)
+	return events
+}
+
+// Parse an appointment like "08:15 - 09:55 / S1|01/A1 / Analysis I", the
+// room is optional
+func parseAppointment(text string, day time.Time) (scheduledEvent, bool) {
+	parts := strings.Split(text, " / ")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	t := tucanTimePattern.FindStringSubmatch(parts[0])
+	if t == nil || len(parts) < 2 {
+		return scheduledEvent{}, false
+	}
+	clock := func(hour, minute string) time.Time {
+		h, _ := strconv.Atoi(hour)
+		m, _ := strconv.Atoi(minute)
+		return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, day.Location())
+	}
+	event := scheduledEvent{Start: clock(t[1], t[2])}
+	event.End = event.Start
+	if t[3] != "" {
+		event.End = clock(t[3], t[4])
+	}
+	if len(parts) == 2 {
+		event.Summary = parts[1]
+	} else {
+		event.Location = parts[1]
+		event.Summary = strings.Join(parts[2:], " / ")
+	}
+	return event, event.Summary != ""
+}
+
+func attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// The UID is made from the title and start, the month view doesn't show the
+// export's UIDs. The change detection matches them by title and start.
+func (e scheduledEvent) uid() string {
+	sum := sha256.Sum256([]byte(e.Summary + "|" + e.Start.UTC().Format(time.RFC3339)))
+	return "month-" + hex.EncodeToString(sum[:8]) + "@tucan-ical"
+}
+
+// Return the appointments as a calendar to merge like a monthly export
+func monthViewCalendar(events []scheduledEvent) string {
+	cal := &icalComponent{name: "VCALENDAR", properties: []*icalProperty{{name: "VERSION", value: "2.0"}}}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	zone := map[string]string{"TZID": changeTimeZone}
+	for _, e := range events {
+		event := &icalComponent{name: "VEVENT"}
+		event.set("UID", nil, e.uid())
+		event.set("DTSTAMP", nil, stamp)
+		event.set("SUMMARY", nil, escapeText(e.Summary))
+		event.set("DTSTART", zone, formatLocalTime(e.Start))
+		event.set("DTEND", zone, formatLocalTime(e.End))
+		if e.Location != "" {
+			event.set("LOCATION", nil, escapeText(e.Location))
+		}
+		cal.components = append(cal.components, event)
+	}
+	return cal.serialize()
+}
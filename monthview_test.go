@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readMonthView(t *testing.T, month string) []scheduledEvent {
+	t.Helper()
+	body, err := os.ReadFile("testdata/monthview/" + month + ".html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := time.ParseInLocation("2006-01", month, berlin)
+	events, err := parseMonthView(string(body), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestParseMonthView(t *testing.T) {
+	for _, tt := range []struct {
+		month string
+		want  []string
+	}{
+		// The days of September and the link to the day view are left out
+		{"2025-10", []string{
+			"01-11-0001-vl Analysis I|S1|01 A1|2025-10-20 08:15|2025-10-20 09:55",
+			"04-00-0108-vu Lineare Algebra|S2|02 C110|2025-10-20 09:00|2025-10-20 10:40",
+			"FOP Sprechstunde||2025-10-21 13:30|2025-10-21 15:10",
+		}},
+		// Without titles the days are numbered, the appointments are lines
+		{"2025-11", []string{
+			"Analysis I|S1|01 A1|2025-11-03 08:15|2025-11-03 09:55",
+			"Lineare Algebra||2025-11-03 16:15|2025-11-03 17:55",
+		}},
+	} {
+		events := readMonthView(t, tt.month)
+		var got []string
+		for _, e := range events {
+			got = append(got, strings.Join([]string{e.Summary, e.Location, e.Start.Format("2006-01-02 15:04"), e.End.Format("2006-01-02 15:04")}, "|"))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.month, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+
+	if _, err := parseMonthView("<html><body><p>Zugang verweigert</p></body></html>", time.Date(2025, 10, 1, 0, 0, 0, 0, berlin)); err == nil {
+		t.Error("expected an error for a page without the month view")
+	}
+}
+
+func TestMonthViewURL(t *testing.T) {
+	got := monthViewURL("123456789", time.Date(2025, 10, 1, 0, 0, 0, 0, berlin))
+	if want := loginScript + "?APPNAME=CampusNet&PRGNAME=MONTH&ARGUMENTS=-N123456789,-N000271,-A01.10.2025,-A,-N1"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestMonthViewMatchesExport(t *testing.T) {
+	export, err := parseICalendar(changesTestCalendar(
+		"tucan-1~01-11-0001-vl Analysis I~S1|01 A1~20251020T081500~20251020T095500",
+		"tucan-2~04-00-0108-vu Lineare Algebra~S2|02 C110~20251020T090000~20251020T104000",
+		"tucan-3~FOP Sprechstunde~~20251021T133000~20251021T151000",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback, err := parseICalendar(monthViewCalendar(readMonthView(t, "2025-10")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := eventByUID(t, fallback, readMonthView(t, "2025-10")[0].uid()); uid.property("DTSTART").params["TZID"] != changeTimeZone {
+		t.Error("expected times in Europe/Berlin")
+	}
+
+	// Switching between the sources doesn't show up as changes
+	before, after := calendarEvents(export), calendarEvents(fallback)
+	if changes := diffEvents(before, after, berlinTime(t, "2025-10-01 00:00")); len(changes) != 0 {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+	if changes := diffEvents(after, before, berlinTime(t, "2025-10-01 00:00")); len(changes) != 0 {
+		t.Errorf("unexpected changes back to the export %+v", changes)
+	}
+}
+
+func TestStatusShowsMonthSources(t *testing.T) {
+	acc := newAccount("alice", t.TempDir())
+	acc.setMonthSources(map[string]string{"2025-11": sourceMonthView, "2025-10": sourceExport})
+	rec := httptest.NewRecorder()
+	httpStatus([]*account{acc})(rec, httptest.NewRequest("GET", "/status", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "<tr><th>2025-10</th><td>export</td></tr>") || !strings.Contains(body, `<tr><th>2025-11</th><td><span class="failing">month view</span></td></tr>`) {
+		t.Errorf("status page lacks the sources:\n%s", body)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Types of notifications sent to webhooks and other notifiers
+const (
+	notifyChanges        = "changes"
+	notifyLoginFailed    = "login_failed"
+	notifyLoginRecovered = "login_recovered"
+	notifyExportFailed   = "export_failed"
+	notifyReminder       = "reminder"
+	notifyGrade          = "grade"
+)
+
+var notificationTypes = []string{notifyChanges, notifyLoginFailed, notifyLoginRecovered, notifyExportFailed, notifyReminder, notifyGrade}
+
+// Alert after this many updates in a row failed to export the calendar
+const exportFailureAlertAfter = 3
+
+// notification is something an account's users should know about, like a
+// changed timetable or a login that started failing
+type notification struct {
+	ID       string        `json:"id"`
+	Type     string        `json:"type"`
+	Account  string        `json:"account"`
+	Time     time.Time     `json:"time"`
+	Changes  []eventChange `json:"changes,omitempty"`
+	Reminder *reminder     `json:"reminder,omitempty"`
+	Grade    *examResult   `json:"grade,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// notifier delivers notifications to one destination. It is called from its
+// own goroutine and may block while retrying.
+type notifier interface {
+	notify(n notification) error
+}
+
+func newNotification(kind, account string) notification {
+	return notification{
+		ID:      newJobID(),
+		Type:    kind,
+		Account: account,
+		Time:    time.Now(),
+	}
+}
+
+// Send a notification to every notifier of the account without blocking the updater
+func (a *account) notify(n notification) {
+	a.notifyMu.Lock()
+	defer a.notifyMu.Unlock()
+	if a.flushed {
+		a.log.Printf("Dropped %s notification, the notifications were already flushed", n.Type)
+		return
+	}
+	for _, nt := range a.notifiers {
+		a.notifying.Add(1)
+		go func() {
+			defer a.notifying.Done()
+			if err := nt.notify(n); err != nil {
+				a.log.Printf("Failed to send %s notification: %v", n.Type, err)
+			}
+		}()
+	}
+}
+
+// Send notifications that are held back for a batch right away, before a
+// single fetch exits. Later notifications are dropped.
+func (a *account) flushNotifications() {
+	a.notifyMu.Lock()
+	a.flushed = true
+	a.notifyMu.Unlock()
+	a.notifying.Wait()
+	for _, nt := range a.notifiers {
+		if batching, ok := nt.(interface{ flush() error }); ok {
+			if err := batching.flush(); err != nil {
+				a.log.Printf("Failed to send held notifications: %v", err)
+			}
+		}
+	}
+}
+
+// Check that every event type is known
+func validNotificationTypes(types []string) error {
+	for _, kind := range types {
+		known := false
+		for _, name := range notificationTypes {
+			known = known || kind == name
+		}
+		if !known {
+			return fmt.Errorf("unknown event %q, use one of %v", kind, notificationTypes)
+		}
+	}
+	return nil
+}
+
+// Return whether a notifier subscribed to types wants n, no types means all
+func wantsNotification(types []string, n notification) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, kind := range types {
+		if kind == n.Type {
+			return true
+		}
+	}
+	return false
+}
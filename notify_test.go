@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestFlushNotificationsWhileNotifying(t *testing.T) {
+	acc := newAccount("alice", t.TempDir())
+	recorder := &recordingNotifier{}
+	acc.notifiers = []notifier{recorder}
+
+	// The reminders notify from their own goroutine while a single fetch
+	// flushes, run with -race to see the WaitGroup misuse this guards against
+	var reminders sync.WaitGroup
+	for range 20 {
+		reminders.Add(1)
+		go func() {
+			defer reminders.Done()
+			acc.notify(newNotification(notifyReminder, "alice"))
+		}()
+	}
+	acc.flushNotifications()
+	recorder.mu.Lock()
+	sent := len(recorder.sent)
+	recorder.mu.Unlock()
+	reminders.Wait()
+
+	acc.notify(newNotification(notifyReminder, "alice"))
+	acc.notifying.Wait()
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.sent) != sent {
+		t.Fatalf("expected notifications after the flush to be dropped, got %d more", len(recorder.sent)-sent)
+	}
+}
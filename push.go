@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pushAttempts = 3
+	pushBackoff  = 5 * time.Second
+)
+
+// pushConfig is a phone push backend of an account: ntfy, Gotify or Matrix
+type pushConfig struct {
+	Type string `yaml:"type"`
+	// Base URL of the server, e.g. https://ntfy.sh or https://matrix.example.org
+	URL string `yaml:"url"`
+	// ntfy topic
+	Topic string `yaml:"topic,omitempty"`
+	// Matrix room ID like !abc:example.org
+	Room string `yaml:"room,omitempty"`
+	// ntfy access token, Gotify application token or Matrix access token
+	Token     secret   `yaml:"token,omitempty"`
+	TokenFile string   `yaml:"token_file,omitempty"`
+	Events    []string `yaml:"events,omitempty"`
+}
+
+// pushNotifier sends a short message per notification to a push backend
+type pushNotifier struct {
+	kind    string
+	baseURL string
+	topic   string
+	room    string
+	token   *secretValue
+	events  []string
+	client  *http.Client
+	backoff time.Duration
+}
+
+func newPushNotifier(cfg pushConfig) *pushNotifier {
+	return &pushNotifier{
+		kind:    cfg.Type,
+		baseURL: strings.TrimSuffix(cfg.URL, "/"),
+		topic:   cfg.Topic,
+		room:    cfg.Room,
+		token:   newSecretValue(string(cfg.Token), cfg.TokenFile),
+		events:  cfg.Events,
+		client:  &http.Client{Timeout: webhookTimeout},
+		backoff: pushBackoff,
+	}
+}
+
+// Return a title and a short text for a notification, one line per change
+func notificationText(n notification) (string, string) {
+	title := n.Account + ": " + notificationTitle(n)
+	if n.Reminder != nil {
+		return title, n.Reminder.String()
+	}
+	if n.Grade != nil {
+		return title, n.Grade.String()
+	}
+	if n.Type != notifyChanges {
+		return title, n.Error
+	}
+	var lines []string
+	for _, change := range n.Changes {
+		label, text := describeChange(change, berlin)
+		lines = append(lines, label+": "+text)
+	}
+	return title, strings.Join(lines, "\n")
+}
+
+// Alerts about the login or export are more urgent than changes
+func isAlert(n notification) bool {
+	return n.Type == notifyLoginFailed || n.Type == notifyExportFailed
+}
+
+func (p *pushNotifier) notify(n notification) error {
+	if !wantsNotification(p.events, n) {
+		return nil
+	}
+	title, text := notificationText(n)
+
+	var method, target string
+	var payload any
+	header := make(http.Header)
+	switch p.kind {
+	case "ntfy":
+		// The JSON API avoids encoding non-ASCII titles in headers
+		priority := 3
+		if isAlert(n) {
+			priority = 4
+		}
+		method, target = "POST", p.baseURL
+		payload = map[string]any{"topic": p.topic, "title": title, "message": text, "priority": priority, "tags": []string{"calendar"}}
+		if token := p.token.get(); token != "" {
+			header.Set("Authorization", "Bearer "+token)
+		}
+	case "gotify":
+		priority := 5
+		if isAlert(n) {
+			priority = 8
+		}
+		method, target = "POST", p.baseURL+"/message"
+		payload = map[string]any{"title": title, "message": text, "priority": priority}
+		header.Set("X-Gotify-Key", p.token.get())
+	case "matrix":
+		// The transaction ID makes a retried request idempotent
+		method = "PUT"
+		target = fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", p.baseURL, url.PathEscape(p.room), url.PathEscape(n.ID))
+		payload = map[string]any{"msgtype": "m.text", "body": strings.TrimSpace(title + "\n" + text)}
+		header.Set("Authorization", "Bearer "+p.token.get())
+	default:
+		return fmt.Errorf("unknown push backend %q", p.kind)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := p.send(method, target, header, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= pushAttempts {
+			return fmt.Errorf("%s: %w", p.kind, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (p *pushNotifier) send(method, target string, header http.Header, body []byte) (bool, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tucan-ical")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, errors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+}
+
+// Check the push backends of an account, field is e.g. "accounts[0].push"
+func validatePush(field string, backends []pushConfig) []error {
+	var errs []error
+	for i, cfg := range backends {
+		name := fmt.Sprintf("%s[%d]", field, i)
+		if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.url: must be an http or https URL", name))
+		}
+		hasToken := cfg.Token != "" || cfg.TokenFile != ""
+		switch cfg.Type {
+		case "ntfy":
+			if cfg.Topic == "" {
+				errs = append(errs, fmt.Errorf("%s.topic: required for ntfy", name))
+			}
+		case "gotify":
+			if !hasToken {
+				errs = append(errs, fmt.Errorf("%s.token: the application token is required for gotify", name))
+			}
+		case "matrix":
+			if !strings.HasPrefix(cfg.Room, "!") {
+				errs = append(errs, fmt.Errorf("%s.room: a room ID like !abc:example.org is required for matrix", name))
+			}
+			if !hasToken {
+				errs = append(errs, fmt.Errorf("%s.token: the access token is required for matrix", name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s.type: %q must be ntfy, gotify or matrix", name, cfg.Type))
+		}
+		if cfg.Token != "" && cfg.TokenFile != "" {
+			errs = append(errs, fmt.Errorf("%s.token: set either token or token_file, not both", name))
+		} else if cfg.TokenFile != "" {
+			if _, err := readSecretFile(cfg.TokenFile); err != nil {
+				errs = append(errs, fmt.Errorf("%s.token_file: %w", name, err))
+			}
+		}
+		if err := validNotificationTypes(cfg.Events); err != nil {
+			errs = append(errs, fmt.Errorf("%s.events: %w", name, err))
+		}
+	}
+	return errs
+}
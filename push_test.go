@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   map[string]any
+}
+
+func startPushServer(t *testing.T, status int) (*httptest.Server, <-chan pushRequest) {
+	t.Helper()
+	requests := make(chan pushRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		req := pushRequest{method: r.Method, path: r.URL.EscapedPath(), header: r.Header}
+		if err := json.Unmarshal(data, &req.body); err != nil {
+			t.Errorf("invalid JSON %q: %v", data, err)
+		}
+		requests <- req
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func roomChangeNotification() notification {
+	n := newNotification(notifyChanges, "alice")
+	n.Changes = []eventChange{{
+		Type:        changeRoom,
+		Summary:     "Analysis I",
+		OldLocation: "S1|01 A1",
+		Location:    "S2|02 C110",
+		Start:       time.Date(2025, 10, 20, 6, 15, 0, 0, time.UTC),
+	}}
+	return n
+}
+
+func TestPushNtfy(t *testing.T) {
+	server, requests := startPushServer(t, http.StatusOK)
+	push := newPushNotifier(pushConfig{Type: "ntfy", URL: server.URL + "/", Topic: "tucan-alice", Token: "tk_secret"})
+	if err := push.notify(roomChangeNotification()); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+
+	req := <-requests
+	if req.method != "POST" || req.path != "/" || req.header.Get("Authorization") != "Bearer tk_secret" {
+		t.Fatalf("unexpected request %s %s %v", req.method, req.path, req.header)
+	}
+	if req.body["topic"] != "tucan-alice" || req.body["title"] != "alice: Calendar changed" || req.body["priority"] != 3.0 {
+		t.Fatalf("unexpected payload %v", req.body)
+	}
+	if req.body["message"] != "Room changed: Analysis I, Mon 20.10.2025 08:15: S1|01 A1 → S2|02 C110" {
+		t.Fatalf("unexpected message %q", req.body["message"])
+	}
+}
+
+func TestPushGotify(t *testing.T) {
+	server, requests := startPushServer(t, http.StatusOK)
+	push := newPushNotifier(pushConfig{Type: "gotify", URL: server.URL, Token: "app-token"})
+	n := newNotification(notifyLoginFailed, "alice")
+	n.Error = "incorrect username or password"
+	if err := push.notify(n); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+
+	req := <-requests
+	if req.path != "/message" || req.header.Get("X-Gotify-Key") != "app-token" {
+		t.Fatalf("unexpected request %s %v", req.path, req.header)
+	}
+	if req.body["priority"] != 8.0 || req.body["message"] != "incorrect username or password" {
+		t.Fatalf("expected an urgent alert, got %v", req.body)
+	}
+}
+
+func TestPushMatrix(t *testing.T) {
+	server, requests := startPushServer(t, http.StatusOK)
+	push := newPushNotifier(pushConfig{Type: "matrix", URL: server.URL, Room: "!room:example.org", Token: "syt_token"})
+	n := roomChangeNotification()
+	if err := push.notify(n); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+
+	req := <-requests
+	wantPath := "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/" + n.ID
+	if req.method != "PUT" || req.path != wantPath || req.header.Get("Authorization") != "Bearer syt_token" {
+		t.Fatalf("unexpected request %s %s %v", req.method, req.path, req.header)
+	}
+	body, _ := req.body["body"].(string)
+	if req.body["msgtype"] != "m.text" || !strings.HasPrefix(body, "alice: Calendar changed\nRoom changed: Analysis I") {
+		t.Fatalf("unexpected payload %v", req.body)
+	}
+}
+
+func TestPushRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	push := newPushNotifier(pushConfig{Type: "ntfy", URL: server.URL, Topic: "tucan"})
+	push.backoff = time.Millisecond
+	if err := push.notify(roomChangeNotification()); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("expected a 502 error, got %v", err)
+	}
+	if calls.Load() != pushAttempts {
+		t.Fatalf("expected %d attempts, got %d", pushAttempts, calls.Load())
+	}
+}
+
+func TestLoadConfigPush(t *testing.T) {
+	path := writeTestConfig(t, `
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+    push:
+      - type: ntfy
+        url: https://ntfy.sh
+      - type: matrix
+        url: https://matrix.example.org
+        room: "#lectures:example.org"
+      - type: pager
+        url: pager.example.org
+`)
+	_, err := loadConfig(path, testEnv(nil))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"accounts[0].push[0].topic: required for ntfy",
+		"accounts[0].push[1].room: a room ID",
+		"accounts[0].push[1].token: the access token is required",
+		`accounts[0].push[2].type: "pager" must be ntfy, gotify or matrix`,
+		"accounts[0].push[2].url: must be an http or https URL",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
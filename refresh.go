@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const maxRefreshJobs = 20
+
+var errUpdatesStopped = errors.New("updates for this account are stopped")
+
+// refreshJob is one run of the calendar update, either scheduled or requested
+// through /admin/refresh or SIGHUP. Requested jobs can be polled by ID.
+type refreshJob struct {
+	ID       string    `json:"id"`
+	Account  string    `json:"account"`
+	Trigger  string    `json:"trigger"`
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Queued   time.Time `json:"queued"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+
+	done chan struct{}
+}
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// refreshState tracks the jobs of one account. Concurrent requests are
+// coalesced into a single queued job.
+type refreshState struct {
+	mu          sync.Mutex
+	minInterval time.Duration
+	trigger     chan struct{}
+	pending     *refreshJob
+	running     *refreshJob
+	lastStarted time.Time
+	stopped     bool
+	jobs        []*refreshJob
+}
+
+// errRefreshTooSoon is returned when the last update started less than the
+// minimum refresh interval ago, to protect the account from too many logins
+type errRefreshTooSoon struct {
+	retryAfter time.Duration
+}
+
+func (e errRefreshTooSoon) Error() string {
+	return fmt.Sprintf("the last update was less than the minimum refresh interval ago, try again in %s", e.retryAfter.Round(time.Second))
+}
+
+func newRefreshState() *refreshState {
+	return &refreshState{trigger: make(chan struct{}, 1)}
+}
+
+// Request an immediate update. A job that is already queued, or one that
+// started within the minimum interval, is returned instead of a new one.
+func (r *refreshState) request(account, trigger string) (*refreshJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return nil, errUpdatesStopped
+	}
+	if r.pending != nil {
+		return r.pending, nil
+	}
+	if since := time.Since(r.lastStarted); since < r.minInterval {
+		if r.running != nil {
+			return r.running, nil
+		}
+		return nil, errRefreshTooSoon{retryAfter: r.minInterval - since}
+	}
+
+	job := r.newJob(account, trigger)
+	r.pending = job
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Called by the updater when an update starts. It picks up a requested job,
+// or creates one for a scheduled update.
+func (r *refreshState) start(account string) *refreshJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := r.pending
+	if job == nil {
+		job = r.newJob(account, "schedule")
+	}
+	r.pending = nil
+	r.running = job
+	r.lastStarted = time.Now()
+	job.State = jobRunning
+	job.Started = r.lastStarted
+	return job
+}
+
+func (r *refreshState) finish(job *refreshJob, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job.Finished = time.Now()
+	job.State = jobSucceeded
+	if err != nil {
+		job.State = jobFailed
+		job.Error = err.Error()
+	}
+	r.running = nil
+	close(job.done)
+}
+
+// Mark the account as stopped and fail a job that is still waiting
+func (r *refreshState) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopped = true
+	if r.pending != nil {
+		r.pending.State = jobFailed
+		r.pending.Error = errUpdatesStopped.Error()
+		r.pending.Finished = time.Now()
+		close(r.pending.done)
+		r.pending = nil
+	}
+}
+
+// Return a copy of the job with the given ID, safe to encode while the job runs
+func (r *refreshState) job(id string) (refreshJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, job := range r.jobs {
+		if job.ID == id {
+			return *job, true
+		}
+	}
+	return refreshJob{}, false
+}
+
+func (r *refreshState) snapshot(job *refreshJob) refreshJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return *job
+}
+
+func (r *refreshState) newJob(account, trigger string) *refreshJob {
+	job := &refreshJob{
+		ID:      newJobID(),
+		Account: account,
+		Trigger: trigger,
+		State:   jobQueued,
+		Queued:  time.Now(),
+		done:    make(chan struct{}),
+	}
+	r.jobs = append(r.jobs, job)
+	if len(r.jobs) > maxRefreshJobs {
+		r.jobs = r.jobs[len(r.jobs)-maxRefreshJobs:]
+	}
+	return job
+}
+
+func newJobID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefreshCoalescesRequests(t *testing.T) {
+	state := newRefreshState()
+
+	first, err := state.request("alice", "http")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	second, err := state.request("alice", "SIGHUP")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if first != second {
+		t.Fatalf("expected concurrent requests to share job %s, got %s", first.ID, second.ID)
+	}
+	if len(state.trigger) != 1 {
+		t.Fatalf("expected exactly one trigger, got %d", len(state.trigger))
+	}
+
+	// The updater picks up the requested job instead of creating a scheduled one
+	job := state.start("alice")
+	if job != first || job.State != jobRunning {
+		t.Fatalf("expected the requested job to run, got %+v", job)
+	}
+	state.finish(job, errors.New("login failed"))
+	select {
+	case <-job.done:
+	default:
+		t.Fatal("expected the job to be done")
+	}
+
+	polled, ok := state.job(first.ID)
+	if !ok || polled.State != jobFailed || polled.Error != "login failed" {
+		t.Fatalf("unexpected polled job: %+v", polled)
+	}
+}
+
+func TestRefreshEnforcesMinimumInterval(t *testing.T) {
+	state := newRefreshState()
+	state.minInterval = time.Hour
+
+	running := state.start("alice")
+
+	// While an update that just started runs, requests return it
+	job, err := state.request("alice", "http")
+	if err != nil || job != running {
+		t.Fatalf("expected the running job, got %v, %v", job, err)
+	}
+
+	state.finish(running, nil)
+	_, err = state.request("alice", "http")
+	var tooSoon errRefreshTooSoon
+	if !errors.As(err, &tooSoon) || tooSoon.retryAfter <= 0 || tooSoon.retryAfter > time.Hour {
+		t.Fatalf("expected errRefreshTooSoon, got %v", err)
+	}
+
+	state.stop()
+	if _, err := state.request("alice", "http"); !errors.Is(err, errUpdatesStopped) {
+		t.Fatalf("expected errUpdatesStopped, got %v", err)
+	}
+}
+
+func TestHttpRefresh(t *testing.T) {
+	alice := newAccount("alice", t.TempDir())
+	alice.auth.tokens = newSecretList([]string{"alice-token-0123456789"}, "")
+	alice.apiTokens = newSecretList([]string{"alice-api-0123456789"}, "")
+	bob := newAccount("bob", t.TempDir())
+	bob.apiTokens = newSecretList([]string{"bob-api-0123456789"}, "")
+	accounts := []*account{alice, bob}
+	handler := httpRefresh(accounts, newSecretList([]string{"admin-token-0123456789"}, ""))
+
+	refresh := func(token, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/refresh"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	if rec := refresh("", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+	// Feed tokens are shared with calendar apps and can't trigger logins
+	if rec := refresh("alice-token-0123456789", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a feed token, got %d", rec.Code)
+	}
+	if rec := refresh("alice-api-0123456789", "?account=bob"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected an API token to only refresh its own account, got %d", rec.Code)
+	}
+
+	rec := refresh("admin-token-0123456789", "")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var response refreshResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Jobs) != 2 || response.Jobs[0].State != jobQueued {
+		t.Fatalf("expected 2 queued jobs, got %+v", response.Jobs)
+	}
+	if rec.Header().Get("Location") != "/admin/refresh/"+response.Jobs[0].ID {
+		t.Fatalf("unexpected Location header %q", rec.Header().Get("Location"))
+	}
+
+	// Finished updates can't be repeated within the minimum interval
+	bob.refresh.minInterval = time.Hour
+	bob.refresh.finish(bob.refresh.start("bob"), nil)
+	rec = refresh("bob-api-0123456789", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d", rec.Code)
+	}
+}
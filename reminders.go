@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const (
+	remindersFile = "reminders.json"
+	// Wake up at least this often, in case the clock jumped
+	maxReminderSleep = 10 * time.Minute
+	// Reminders missed while not running are sent late up to this long
+	maxReminderDelay = time.Hour
+)
+
+// Kinds of events, each with its own reminder lead times
+const (
+	kindLecture  = "lecture"
+	kindExercise = "exercise"
+	kindExam     = "exam"
+	kindDeadline = "deadline"
+)
+
+var eventKinds = []string{kindLecture, kindExercise, kindExam, kindDeadline}
+
+// reminderConfig enables reminders before events. Without lead_times exams
+// are reminded a day and an hour before, lectures and exercises 15 minutes
+// and registration deadlines three days and a day.
+type reminderConfig struct {
+	LeadTimes map[string][]duration `yaml:"lead_times,omitempty"`
+	Mute      []muteConfig          `yaml:"mute,omitempty"`
+}
+
+// muteConfig silences reminders for courses whose title matches the regular
+// expression, optionally only for some kinds of events
+type muteConfig struct {
+	Course string   `yaml:"course"`
+	Kinds  []string `yaml:"kinds,omitempty"`
+}
+
+var defaultLeadTimes = map[string][]time.Duration{
+	kindExam:     {24 * time.Hour, time.Hour},
+	kindLecture:  {15 * time.Minute},
+	kindExercise: {15 * time.Minute},
+	kindDeadline: {72 * time.Hour, 24 * time.Hour},
+}
+
+// reminder is the event a reminder notification is about
+type reminder struct {
+	Summary  string    `json:"summary"`
+	Location string    `json:"location,omitempty"`
+	Kind     string    `json:"kind"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end,omitzero"`
+	Lead     duration  `json:"lead"`
+}
+
+type muteRule struct {
+	course *regexp.Regexp
+	kinds  []string
+}
+
+// reminders decides which events of an account are reminded when
+type reminders struct {
+	leads map[string][]time.Duration
+	mute  []muteRule
+}
+
+func newReminders(cfg reminderConfig) (*reminders, error) {
+	var errs []error
+	r := &reminders{leads: defaultLeadTimes}
+	if len(cfg.LeadTimes) > 0 {
+		r.leads = make(map[string][]time.Duration)
+		for kind, leads := range cfg.LeadTimes {
+			if !validEventKind(kind) {
+				errs = append(errs, fmt.Errorf("lead_times: unknown kind %q, use one of %v", kind, eventKinds))
+			}
+			for _, lead := range leads {
+				if lead <= 0 {
+					errs = append(errs, fmt.Errorf("lead_times.%s: must be positive", kind))
+				}
+				r.leads[kind] = append(r.leads[kind], time.Duration(lead))
+			}
+		}
+	}
+	for i, mute := range cfg.Mute {
+		course, err := regexp.Compile(mute.Course)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mute[%d].course: %w", i, err))
+			continue
+		}
+		for _, kind := range mute.Kinds {
+			if !validEventKind(kind) {
+				errs = append(errs, fmt.Errorf("mute[%d].kinds: unknown kind %q, use one of %v", i, kind, eventKinds))
+			}
+		}
+		r.mute = append(r.mute, muteRule{course: course, kinds: mute.Kinds})
+	}
+	return r, errors.Join(errs...)
+}
+
+func validEventKind(kind string) bool {
+	for _, known := range eventKinds {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Guess the kind of an event from its title
+func eventKind(summary string) string {
+	return parseCourseInfo(summary, "").kind()
+}
+
+// Return the kind of the event's course, or guess it from the title if it
+// has no course information
+func (e calendarEvent) kind() string {
+	if e.Course != (courseInfo{}) {
+		return e.Course.kind()
+	}
+	return eventKind(e.Summary)
+}
+
+func (r *reminders) muted(event calendarEvent, kind string) bool {
+	for _, rule := range r.mute {
+		if !rule.course.MatchString(event.Summary) {
+			continue
+		}
+		if len(rule.kinds) == 0 {
+			return true
+		}
+		for _, muted := range rule.kinds {
+			if muted == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Return the reminders due in (from, to] for events that haven't started at to
+func (r *reminders) due(events []calendarEvent, from, to time.Time) []reminder {
+	var due []reminder
+	for _, event := range events {
+		kind := event.kind()
+		if !event.Start.After(to) || r.muted(event, kind) {
+			continue
+		}
+		for _, lead := range r.leads[kind] {
+			at := event.Start.Add(-lead)
+			if at.After(from) && !at.After(to) {
+				due = append(due, reminder{
+					Summary:  event.Summary,
+					Location: event.Location,
+					Kind:     kind,
+					Start:    event.Start,
+					End:      event.End,
+					Lead:     duration(lead),
+				})
+			}
+		}
+	}
+	return due
+}
+
+// Return when the next reminder after t is due, or the zero time
+func (r *reminders) next(events []calendarEvent, t time.Time) time.Time {
+	var next time.Time
+	for _, event := range events {
+		kind := event.kind()
+		if r.muted(event, kind) {
+			continue
+		}
+		for _, lead := range r.leads[kind] {
+			at := event.Start.Add(-lead)
+			if at.After(t) && (next.IsZero() || at.Before(next)) {
+				next = at
+			}
+		}
+	}
+	return next
+}
+
+// Describe the reminder like "Analysis I in 15 minutes, Mon 20.10.2025 08:15–09:55 in S1|01 A1"
+func (r reminder) String() string {
+	text := fmt.Sprintf("%s in %s, %s", r.Summary, formatLead(time.Duration(r.Lead)), formatEventTime(r.Start, r.End, berlin))
+	return withLocation(text, r.Location)
+}
+
+func formatLead(lead time.Duration) string {
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case lead%(24*time.Hour) == 0:
+		return plural(int64(lead/(24*time.Hour)), "day")
+	case lead%time.Hour == 0:
+		return plural(int64(lead/time.Hour), "hour")
+	}
+	return plural(int64(lead/time.Minute), "minute")
+}
+
+// Send the reminders of an account until the process exits. The time up to
+// which reminders were sent is stored, so reminders that fell into a restart
+// are sent afterwards if their event hasn't started yet and they are at most
+// maxReminderDelay late.
+func runReminders(acc *account) {
+	statePath := filepath.Join(acc.dataDir, remindersFile)
+	checked := loadReminderState(statePath)
+	if earliest := time.Now().Add(-maxReminderDelay); checked.Before(earliest) {
+		checked = earliest
+	}
+
+	for {
+		var events []calendarEvent
+		if data, err := os.ReadFile(acc.icalPath()); err == nil {
+			if cal, err := parseICalendar(string(data)); err == nil {
+				events = calendarEvents(cal)
+			} else {
+				acc.log.Printf("Failed to parse the calendar for reminders: %v", err)
+			}
+		}
+
+		now := time.Now()
+		for _, due := range acc.reminders.due(events, checked, now) {
+			n := newNotification(notifyReminder, acc.name)
+			n.Reminder = &due
+			acc.notify(n)
+		}
+		if now.After(checked) {
+			checked = now
+			if err := saveReminderState(statePath, checked); err != nil {
+				acc.log.Printf("Failed to save the reminder state: %v", err)
+			}
+		}
+
+		// Sleep until the next reminder, or until the calendar changes
+		sleep := maxReminderSleep
+		if next := acc.reminders.next(events, checked); !next.IsZero() {
+			sleep = min(time.Until(next), maxReminderSleep)
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-timer.C:
+		case <-acc.calendarUpdated:
+			timer.Stop()
+		}
+	}
+}
+
+func loadReminderState(path string) time.Time {
+	var state struct {
+		Checked time.Time `json:"checked"`
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	return state.Checked
+}
+
+func saveReminderState(path string, checked time.Time) error {
+	data, err := json.Marshal(map[string]time.Time{"checked": checked})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func reminderTestEvents(t *testing.T) []calendarEvent {
+	t.Helper()
+	return []calendarEvent{
+		{UID: "1", Summary: "01-10-0001-vl Analysis I", Location: "S1|01 A1", Start: berlinTime(t, "2025-10-20 08:15"), End: berlinTime(t, "2025-10-20 09:55")},
+		{UID: "2", Summary: "01-10-0001-ue Analysis I", Location: "S1|01 A3", Start: berlinTime(t, "2025-10-20 13:30"), End: berlinTime(t, "2025-10-20 15:10")},
+		{UID: "3", Summary: "Klausur Analysis I", Location: "S1|01 A1", Start: berlinTime(t, "2025-10-21 10:00"), End: berlinTime(t, "2025-10-21 12:00")},
+		{UID: "4", Summary: "20-00-0005-iv Grundlagen der Informatik", Start: berlinTime(t, "2025-10-21 08:15")},
+	}
+}
+
+func TestEventKind(t *testing.T) {
+	tests := map[string]string{
+		"01-10-0001-vl Analysis I":          kindLecture,
+		"20-00-0005-iv Funktionale Program": kindLecture,
+		"01-10-0001-ue Analysis I":          kindExercise,
+		"Tutorium Lineare Algebra":          kindExercise,
+		"Klausur Analysis I":                kindExam,
+		"Nachprüfung Statistik":             kindExam,
+	}
+	for summary, want := range tests {
+		if got := eventKind(summary); got != want {
+			t.Errorf("eventKind(%q) = %s, want %s", summary, got, want)
+		}
+	}
+}
+
+func TestRemindersDue(t *testing.T) {
+	r, err := newReminders(reminderConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := reminderTestEvents(t)
+
+	var got []string
+	for _, window := range [][2]string{{"2025-10-20 07:55", "2025-10-20 08:05"}, {"2025-10-20 09:00", "2025-10-20 10:00"}} {
+		for _, due := range r.due(events, berlinTime(t, window[0]), berlinTime(t, window[1])) {
+			got = append(got, due.String())
+		}
+	}
+	want := []string{
+		"01-10-0001-vl Analysis I in 15 minutes, Mon 20.10.2025 08:15–09:55 in S1|01 A1",
+		"Klausur Analysis I in 1 day, Tue 21.10.2025 10:00–12:00 in S1|01 A1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected reminders:\n%s", strings.Join(got, "\n"))
+	}
+
+	next := r.next(events, berlinTime(t, "2025-10-20 10:00"))
+	if want := berlinTime(t, "2025-10-20 13:15"); !next.Equal(want) {
+		t.Fatalf("next reminder at %v, want %v", next, want)
+	}
+
+	// A reminder that is late isn't sent once the event started
+	if due := r.due(events, berlinTime(t, "2025-10-20 07:55"), berlinTime(t, "2025-10-20 08:20")); len(due) != 0 {
+		t.Fatalf("expected no reminders, got %+v", due)
+	}
+}
+
+func TestRemindersLeadTimesAndMute(t *testing.T) {
+	r, err := newReminders(reminderConfig{
+		LeadTimes: map[string][]duration{kindExam: {duration(2 * time.Hour)}, kindLecture: {duration(30 * time.Minute)}},
+		Mute: []muteConfig{
+			{Course: "Analysis", Kinds: []string{kindLecture}},
+			{Course: "^20-00-0005"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := reminderTestEvents(t)
+
+	due := r.due(events, berlinTime(t, "2025-10-20 00:00"), berlinTime(t, "2025-10-21 09:00"))
+	if len(due) != 1 || due[0].Summary != "Klausur Analysis I" || time.Duration(due[0].Lead) != 2*time.Hour {
+		t.Fatalf("expected only the exam two hours before, got %+v", due)
+	}
+	if next := r.next(events, berlinTime(t, "2025-10-20 00:00")); !next.Equal(berlinTime(t, "2025-10-21 08:00")) {
+		t.Fatalf("unexpected next reminder %v", next)
+	}
+}
+
+func TestFormatLead(t *testing.T) {
+	tests := map[time.Duration]string{
+		15 * time.Minute: "15 minutes",
+		time.Hour:        "1 hour",
+		90 * time.Minute: "90 minutes",
+		48 * time.Hour:   "2 days",
+	}
+	for lead, want := range tests {
+		if got := formatLead(lead); got != want {
+			t.Errorf("formatLead(%v) = %q, want %q", lead, got, want)
+		}
+	}
+}
+
+func TestLoadConfigReminders(t *testing.T) {
+	path := writeTestConfig(t, `
+reminders:
+  lead_times:
+    seminar: [1h]
+    exam: [-1h]
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+    reminders:
+      mute:
+        - course: "(Analysis"
+        - course: Analysis
+          kinds: [talk]
+`)
+	_, err := loadConfig(path, testEnv(nil))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		`reminders.lead_times: unknown kind "seminar"`,
+		"reminders.lead_times.exam: must be positive",
+		"accounts[0].reminders.mute[0].course:",
+		`accounts[0].reminders.mute[1].kinds: unknown kind "talk"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Actions of a rewrite rule
+const (
+	rewriteReplace = "replace"
+	rewriteSet     = "set"
+	rewriteAppend  = "append"
+	rewriteDrop    = "drop"
+)
+
+var rewriteActions = []string{rewriteReplace, rewriteSet, rewriteAppend, rewriteDrop}
+
+// rewriteConfig changes the events it matches, e.g. shortens the title of a
+// course. Rules are applied in order, each sees the events as the rules
+// before it left them.
+type rewriteConfig struct {
+	// Course numbers or parts of the title like ?course= on the feed, any may match
+	Courses []string `yaml:"courses,omitempty"`
+	// The property to match and change, SUMMARY by default
+	Property string `yaml:"property,omitempty"`
+	// Regular expression on the property, without one every event matches
+	Match string `yaml:"match,omitempty"`
+	// replace, set, append or drop
+	Action string `yaml:"action"`
+	// The replacement or new text, $1 and ${name} refer to groups of match
+	Value string `yaml:"value,omitempty"`
+}
+
+type rewriteRule struct {
+	courses  eventFilter
+	property string
+	match    *regexp.Regexp
+	action   string
+	value    string
+}
+
+// rewriteEdit is a change made by a rule, listed by the rewrite command
+type rewriteEdit struct {
+	UID      string
+	Summary  string
+	Property string
+	Old      string
+	New      string
+	Dropped  bool
+}
+
+// Parse the rewrite rules, errors start with the index like "[0].match: …"
+func newRewriteRules(cfgs []rewriteConfig) ([]rewriteRule, error) {
+	var rules []rewriteRule
+	var errs []error
+	for i, cfg := range cfgs {
+		rule := rewriteRule{
+			property: strings.ToUpper(strings.TrimSpace(cfg.Property)),
+			action:   cfg.Action,
+			value:    cfg.Value,
+		}
+		for _, course := range cfg.Courses {
+			rule.courses.courses = append(rule.courses.courses, strings.ToLower(strings.TrimSpace(course)))
+		}
+		if rule.property == "" {
+			rule.property = "SUMMARY"
+		}
+		if cfg.Match != "" {
+			re, err := regexp.Compile(cfg.Match)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("[%d].match: %w", i, err))
+			}
+			rule.match = re
+		}
+		switch cfg.Action {
+		case rewriteReplace:
+			if cfg.Match == "" {
+				errs = append(errs, fmt.Errorf("[%d].match: required for replace", i))
+			}
+		case rewriteSet, rewriteAppend:
+		case rewriteDrop:
+			if cfg.Value != "" {
+				errs = append(errs, fmt.Errorf("[%d].value: not used by drop", i))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("[%d].action: unknown action %q, use one of %v", i, cfg.Action, rewriteActions))
+		}
+		if rule.property == "UID" || dateTimeProperties[rule.property] {
+			errs = append(errs, fmt.Errorf("[%d].property: %s can't be rewritten", i, rule.property))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errors.Join(errs...)
+}
+
+// Properties holding a single text, matched and written unescaped
+func textProperty(name string) bool {
+	switch name {
+	case "SUMMARY", "LOCATION", "DESCRIPTION", "COMMENT", "CONTACT":
+		return true
+	}
+	return strings.HasPrefix(name, "X-TUCAN-")
+}
+
+// Return the value of the rule's property, empty if the event has none
+func (r rewriteRule) get(event *icalComponent) string {
+	if textProperty(r.property) {
+		return unescapeText(event.value(r.property))
+	}
+	return event.value(r.property)
+}
+
+// Change the value of the rule's property and keep its parameters
+func (r rewriteRule) put(event *icalComponent, value string) {
+	if textProperty(r.property) {
+		value = escapeText(value)
+	}
+	if prop := event.property(r.property); prop != nil {
+		prop.value = value
+		return
+	}
+	event.set(r.property, nil, value)
+}
+
+// Apply the rules to every event in order and return what they changed
+func rewriteEvents(cal *icalComponent, rules []rewriteRule) []rewriteEdit {
+	if len(rules) == 0 {
+		return nil
+	}
+	var edits []rewriteEdit
+	cal.components = slices.DeleteFunc(cal.components, func(event *icalComponent) bool {
+		if event.name != "VEVENT" {
+			return false
+		}
+		uid := event.value("UID")
+		summary := unescapeText(event.value("SUMMARY"))
+		// Courses are selected by what annotateCourses found, which the rules don't change
+		info := eventCourseInfo(event)
+		for _, rule := range rules {
+			if !rule.courses.matches(info) {
+				continue
+			}
+			old := rule.get(event)
+			var groups []int
+			if rule.match != nil {
+				if groups = rule.match.FindStringSubmatchIndex(old); groups == nil {
+					continue
+				}
+			}
+
+			var value string
+			switch rule.action {
+			case rewriteDrop:
+				edits = append(edits, rewriteEdit{UID: uid, Summary: summary, Dropped: true})
+				return true
+			case rewriteReplace:
+				value = rule.match.ReplaceAllString(old, rule.value)
+			case rewriteSet:
+				value = rule.expand(old, groups)
+			case rewriteAppend:
+				value = old + rule.expand(old, groups)
+			}
+			if value == old {
+				continue
+			}
+			rule.put(event, value)
+			edits = append(edits, rewriteEdit{UID: uid, Summary: summary, Property: rule.property, Old: old, New: value})
+		}
+		return false
+	})
+	return edits
+}
+
+// Fill the groups of the match into the value, a rule without match uses it as is
+func (r rewriteRule) expand(old string, groups []int) string {
+	if r.match == nil {
+		return r.value
+	}
+	return string(r.match.ExpandString(nil, r.value, old, groups))
+}
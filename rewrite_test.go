@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func rewriteTestCalendar(t *testing.T) *icalComponent {
+	t.Helper()
+	cal, err := parseICalendar(changesTestCalendar(
+		"gdi~20-00-0005-iv Grundlagen der Informatik I~S1|01 A1~20251013T081500~20251013T095500",
+		"mathe~04-00-0108-ue Mathematik I für Informatiker - Gruppe 07~S2|02 C110~20251014T081500~20251014T095500",
+		"sprech~Sprechstunde~~20251015T100000~20251015T110000",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotateCourses(cal)
+	return cal
+}
+
+func mustRewriteRules(t *testing.T, cfgs ...rewriteConfig) []rewriteRule {
+	t.Helper()
+	rules, err := newRewriteRules(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestRewriteEventsActions(t *testing.T) {
+	cal := rewriteTestCalendar(t)
+	edits := rewriteEvents(cal, mustRewriteRules(t,
+		rewriteConfig{Match: `^\S+ `, Action: rewriteReplace},
+		rewriteConfig{Courses: []string{"04-00"}, Property: "location", Match: `^(S\d)\|`, Action: rewriteSet, Value: "Stadtmitte $1"},
+		rewriteConfig{Property: "DESCRIPTION", Action: rewriteAppend, Value: "Notes, see Moodle"},
+		rewriteConfig{Match: "^Sprechstunde$", Action: rewriteDrop},
+	))
+
+	if events := cal.children("VEVENT"); len(events) != 2 {
+		t.Fatalf("expected the office hour to be dropped, %d events left", len(events))
+	}
+	gdi, mathe := eventByUID(t, cal, "gdi"), eventByUID(t, cal, "mathe")
+	tests := []struct {
+		event      *icalComponent
+		prop, want string
+	}{
+		{gdi, "SUMMARY", "Grundlagen der Informatik I"},
+		{gdi, "LOCATION", "S1|01 A1"},
+		{mathe, "SUMMARY", "Mathematik I für Informatiker - Gruppe 07"},
+		{mathe, "LOCATION", "Stadtmitte S2"},
+		// Text is written escaped
+		{mathe, "DESCRIPTION", `Notes\, see Moodle`},
+		// The course information stays as it was parsed
+		{mathe, propCourseCode, "04-00-0108"},
+	}
+	for _, tt := range tests {
+		if got := tt.event.value(tt.prop); got != tt.want {
+			t.Errorf("%s %s = %q, want %q", tt.event.value("UID"), tt.prop, got, tt.want)
+		}
+	}
+
+	var summary []string
+	for _, edit := range edits {
+		if edit.Dropped {
+			summary = append(summary, edit.UID+" dropped")
+		} else {
+			summary = append(summary, edit.UID+" "+edit.Property)
+		}
+	}
+	if got := strings.Join(summary, ", "); got != "gdi SUMMARY, gdi DESCRIPTION, mathe SUMMARY, mathe LOCATION, mathe DESCRIPTION, sprech DESCRIPTION, sprech dropped" {
+		t.Errorf("unexpected edits %s", got)
+	}
+}
+
+// Every rule sees the result of the rules before it
+func TestRewriteEventsOrder(t *testing.T) {
+	shorten := rewriteConfig{Match: `^20-00-0005-iv Grundlagen der Informatik I$`, Action: rewriteSet, Value: "GdI I"}
+	suffix := rewriteConfig{Match: `^GdI`, Action: rewriteAppend, Value: " (Pflicht)"}
+	drop := rewriteConfig{Match: `Pflicht`, Action: rewriteDrop}
+
+	tests := []struct {
+		name  string
+		rules []rewriteConfig
+		want  string
+	}{
+		{"shorten then append", []rewriteConfig{shorten, suffix}, "GdI I (Pflicht)"},
+		// The suffix rule runs before the title is shortened and doesn't match
+		{"append then shorten", []rewriteConfig{suffix, shorten}, "GdI I"},
+		{"drop sees the suffix", []rewriteConfig{shorten, suffix, drop}, ""},
+		{"drop before the suffix", []rewriteConfig{shorten, drop, suffix}, "GdI I (Pflicht)"},
+		// Later rules select courses by the original title
+		{"courses after rewrite", []rewriteConfig{shorten, {Courses: []string{"informatik"}, Action: rewriteAppend, Value: "!"}}, "GdI I!"},
+	}
+	for _, tt := range tests {
+		cal := rewriteTestCalendar(t)
+		rewriteEvents(cal, mustRewriteRules(t, tt.rules...))
+		got := ""
+		for _, event := range cal.children("VEVENT") {
+			if event.value("UID") == "gdi" {
+				got = event.value("SUMMARY")
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: summary %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewRewriteRulesErrors(t *testing.T) {
+	_, err := newRewriteRules([]rewriteConfig{
+		{Action: rewriteReplace},
+		{Match: "(", Action: rewriteSet},
+		{Action: "rename"},
+		{Property: "dtstart", Action: rewriteSet, Value: "x"},
+		{Action: rewriteDrop, Value: "x"},
+	})
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{"[0].match: required", "[1].match:", "[2].action: unknown action", "[3].property: DTSTART", "[4].value:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in:\n%v", want, err)
+		}
+	}
+}
+
+func TestWriteRewriteEdits(t *testing.T) {
+	var b strings.Builder
+	writeRewriteEdits(&b, []rewriteEdit{
+		{UID: "gdi", Summary: "20-00-0005-iv GdI", Property: "SUMMARY", Old: "20-00-0005-iv GdI", New: "GdI"},
+		{UID: "gdi", Summary: "20-00-0005-iv GdI", Property: "LOCATION", Old: "S1|01 A1", New: "A1"},
+		{UID: "sprech", Summary: "Sprechstunde", Dropped: true},
+	})
+	want := "20-00-0005-iv GdI (gdi)\n" +
+		"  SUMMARY: \"20-00-0005-iv GdI\" → \"GdI\"\n" +
+		"  LOCATION: \"S1|01 A1\" → \"A1\"\n" +
+		"Sprechstunde (sprech)\n" +
+		"  dropped\n"
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestFinishCalendarKeepsOriginal(t *testing.T) {
+	merged := changesTestCalendar("gdi~20-00-0005-iv Grundlagen der Informatik I~S1|01 A1~20251013T081500~20251013T095500")
+	acc := newAccount("alice", t.TempDir())
+	acc.rewrites = mustRewriteRules(t, rewriteConfig{Action: rewriteAppend, Value: " (Pflicht)"})
+	original, finished, err := acc.finishCalendar(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(finished, "SUMMARY:20-00-0005-iv Grundlagen der Informatik I (Pflicht)") {
+		t.Fatalf("rules not applied to the calendar:\n%s", finished)
+	}
+	if strings.Contains(original, "Pflicht") {
+		t.Fatalf("rules applied to the original calendar:\n%s", original)
+	}
+
+	// A changed rule leaves the original as it was, so there are no changes
+	acc.rewrites = mustRewriteRules(t, rewriteConfig{Action: rewriteSet, Value: "GdI I"})
+	next, _, err := acc.finishCalendar(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := acc.changes.record("alice", original, next, map[string]bool{"2025-10": true}, time.Now())
+	if err != nil || set != nil {
+		t.Fatalf("expected no changes after editing a rule, got %+v, %v", set, err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The buildings shipped with the binary, see rooms.yaml
+//
+//go:embed rooms.yaml
+var builtinRooms []byte
+
+// building is an entry of the room database
+type building struct {
+	Name    string  `yaml:"name"`
+	Address string  `yaml:"address,omitempty"`
+	Campus  string  `yaml:"campus,omitempty"`
+	Lat     float64 `yaml:"lat"`
+	Lon     float64 `yaml:"lon"`
+}
+
+// roomDB maps building codes like "S1|01" to their buildings
+type roomDB map[string]building
+
+// Rooms like "S1|01 A1" or "S101/A1", the building code and the room. A
+// location that was already enriched has a comma and doesn't match.
+var roomPattern = regexp.MustCompile(`(?i)^\s*([a-z]\d)\s*\|?\s*(\d{2})(?:\s*[/ ]\s*([^,]+?))?\s*$`)
+
+// Load the built-in buildings and those of path, which replace built-in ones
+// with the same code
+func loadRooms(path string) (roomDB, error) {
+	db := make(roomDB)
+	if err := db.read(builtinRooms); err != nil {
+		return nil, fmt.Errorf("built-in rooms: %w", err)
+	}
+	if path == "" {
+		return db, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.read(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+func (db roomDB) read(data []byte) error {
+	var buildings map[string]building
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&buildings); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	var errs []error
+	for _, code := range slices.Sorted(maps.Keys(buildings)) {
+		b := buildings[code]
+		normalized, _, ok := parseRoom(code)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: not a building code like S1|01", code))
+			continue
+		}
+		if b.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: must not be empty", code))
+		}
+		if b.Lat < -90 || b.Lat > 90 || b.Lon < -180 || b.Lon > 180 || (b.Lat == 0 && b.Lon == 0) {
+			errs = append(errs, fmt.Errorf("%s: lat and lon must be valid coordinates", code))
+		}
+		db[normalized] = b
+	}
+	return errors.Join(errs...)
+}
+
+// Split a room like "S101/A1" into the building code "S1|01" and the room "A1"
+func parseRoom(location string) (code, room string, ok bool) {
+	m := roomPattern.FindStringSubmatch(location)
+	if m == nil {
+		return "", "", false
+	}
+	return strings.ToUpper(m[1]) + "|" + m[2], m[3], true
+}
+
+// Return a link to the building on OpenStreetMap
+func (b building) mapURL() string {
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.6f&mlon=%.6f#map=18/%.6f/%.6f", b.Lat, b.Lon, b.Lat, b.Lon)
+}
+
+// Add the building of every event's room: its name and address to LOCATION,
+// GEO and X-APPLE-STRUCTURED-LOCATION for the map in calendar apps and a map
+// link to DESCRIPTION. Rooms in unknown buildings are left as they are.
+func enrichLocations(cal *icalComponent, db roomDB) {
+	for _, event := range cal.children("VEVENT") {
+		prop := event.property("LOCATION")
+		if prop == nil {
+			continue
+		}
+		location := unescapeText(prop.value)
+		code, _, ok := parseRoom(location)
+		b, known := db[code]
+		if !ok || !known {
+			continue
+		}
+
+		title := location + ", " + b.Name
+		prop.value = escapeText(title)
+		if b.Address != "" {
+			prop.value = escapeText(title + ", " + b.Address)
+		}
+		event.set("GEO", nil, fmt.Sprintf("%.6f;%.6f", b.Lat, b.Lon))
+		// Parameter values can't contain quotes
+		params := map[string]string{
+			"VALUE":          "URI",
+			"X-APPLE-RADIUS": "70",
+			"X-TITLE":        strings.ReplaceAll(title, `"`, "'"),
+		}
+		if b.Address != "" {
+			params["X-ADDRESS"] = strings.ReplaceAll(b.Address, `"`, "'")
+		}
+		event.set("X-APPLE-STRUCTURED-LOCATION", params, fmt.Sprintf("geo:%.6f,%.6f", b.Lat, b.Lon))
+
+		description := unescapeText(event.value("DESCRIPTION"))
+		if link := b.mapURL(); !strings.Contains(description, link) {
+			if description != "" {
+				description += "\n\n"
+			}
+			event.set("DESCRIPTION", nil, escapeText(description+"Map: "+link))
+		}
+	}
+}
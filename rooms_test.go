@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRoom(t *testing.T) {
+	tests := []struct {
+		location, code, room string
+		ok                   bool
+	}{
+		{"S1|01 A1", "S1|01", "A1", true},
+		{"S101/A1", "S1|01", "A1", true},
+		{"s2|02 C110", "S2|02", "C110", true},
+		{"L4|02", "L4|02", "", true},
+		{"S1|01 A1, karo 5 (Audimax)", "", "", false},
+		{"Online", "", "", false},
+	}
+	for _, tt := range tests {
+		code, room, ok := parseRoom(tt.location)
+		if code != tt.code || room != tt.room || ok != tt.ok {
+			t.Errorf("parseRoom(%q) = %q, %q, %v, want %q, %q, %v", tt.location, code, room, ok, tt.code, tt.room, tt.ok)
+		}
+	}
+}
+
+func TestLoadRooms(t *testing.T) {
+	db, err := loadRooms("")
+	if err != nil {
+		t.Fatalf("built-in rooms: %v", err)
+	}
+	if db["S2|02"].Name != "Robert-Piloty-Gebäude" {
+		t.Fatalf("unexpected S2|02 %+v", db["S2|02"])
+	}
+
+	path := filepath.Join(t.TempDir(), "rooms.yaml")
+	os.WriteFile(path, []byte("S202:\n  name: Piloty\n  lat: 49.8773\n  lon: 8.6544\nS3|06:\n  name: Residenzschloss\n  lat: 49.8727\n  lon: 8.6538\n"), 0644)
+	if db, err = loadRooms(path); err != nil {
+		t.Fatal(err)
+	}
+	if db["S2|02"].Name != "Piloty" || db["S3|06"].Name != "Residenzschloss" || db["S1|01"].Name == "" {
+		t.Fatalf("rooms file not merged: %+v", db)
+	}
+
+	os.WriteFile(path, []byte("Mensa:\n  name: Mensa\n  lat: 1\n  lon: 1\nS1|05:\n  lat: 91\n"), 0644)
+	_, err = loadRooms(path)
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{"Mensa: not a building code", "S1|05.name: must not be empty", "S1|05: lat and lon"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in:\n%v", want, err)
+		}
+	}
+}
+
+func TestEnrichLocations(t *testing.T) {
+	cal, err := parseICalendar(changesTestCalendar(
+		"piloty~Analysis I~S2|02 C110~20251013T081500~20251013T095500",
+		"unknown~Analysis I~S9|99 1~20251014T081500~20251014T095500",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _ := loadRooms("")
+	enrichLocations(cal, db)
+	// Enriching again changes nothing
+	enrichLocations(cal, db)
+	cal, err = parseICalendar(cal.serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := eventByUID(t, cal, "piloty")
+	want := map[string]string{
+		"LOCATION":                    `LOCATION:S2|02 C110\, Robert-Piloty-Gebäude\, Hochschulstraße 10\, 64289 Darmstadt`,
+		"GEO":                         "GEO:49.877330;8.654370",
+		"X-APPLE-STRUCTURED-LOCATION": `X-APPLE-STRUCTURED-LOCATION;VALUE=URI;X-ADDRESS="Hochschulstraße 10, 64289 Darmstadt";X-APPLE-RADIUS=70;X-TITLE="S2|02 C110, Robert-Piloty-Gebäude":geo:49.877330,8.654370`,
+		"DESCRIPTION":                 `DESCRIPTION:Map: https://www.openstreetmap.org/?mlat=49.877330&mlon=8.654370#map=18/49.877330/8.654370`,
+	}
+	for name, line := range want {
+		if got := event.property(name); got == nil || got.String() != line {
+			t.Errorf("%s is %v, want %s", name, got, line)
+		}
+	}
+
+	unknown := eventByUID(t, cal, "unknown")
+	if unknown.value("LOCATION") != "S9|99 1" || unknown.property("GEO") != nil {
+		t.Errorf("unknown building was changed: %s", unknown.serialize())
+	}
+}
+
+func TestHttpTucanRooms(t *testing.T) {
+	acc := newAccount("test", t.TempDir())
+	acc.rooms, _ = loadRooms("")
+	calendar := changesTestCalendar("1~Analysis I~S1|01 A1~20251013T081500~20251013T095500")
+	if err := os.WriteFile(acc.icalPath(), []byte(calendar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	enabled := true
+	acc.calendar.Rooms = &enabled
+
+	for query, want := range map[string]bool{"": true, "?rooms=false": false, "?rooms=0": false} {
+		rec := httptest.NewRecorder()
+		httpTucan(acc)(rec, httptest.NewRequest("GET", "/tucan.ics"+query, nil))
+		if got := strings.Contains(rec.Body.String(), "GEO:"); got != want {
+			t.Errorf("GET /tucan.ics%s: GEO %v, want %v", query, got, want)
+		}
+	}
+	rec := httptest.NewRecorder()
+	httpTucan(acc)(rec, httptest.NewRequest("GET", "/tucan.ics?rooms=maybe", nil))
+	if rec.Code != 400 {
+		t.Errorf("expected 400 for an invalid value, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleConfig decides when the calendar is updated. Windows shorten or
+// lengthen the interval at certain times, cron expressions add fixed update
+// times. Without windows and cron the calendar is updated every interval.
+type scheduleConfig struct {
+	Timezone string         `yaml:"timezone,omitempty"`
+	Interval duration       `yaml:"interval,omitempty"`
+	Jitter   duration       `yaml:"jitter,omitempty"`
+	Windows  []windowConfig `yaml:"windows,omitempty"`
+	Cron     []string       `yaml:"cron,omitempty"`
+}
+
+// windowConfig applies its interval on the given weekdays between from and to,
+// optionally only on certain dates like the start of the semester
+type windowConfig struct {
+	Days     string   `yaml:"days,omitempty"`
+	From     string   `yaml:"from,omitempty"`
+	To       string   `yaml:"to,omitempty"`
+	Dates    []string `yaml:"dates,omitempty"`
+	Interval duration `yaml:"interval"`
+}
+
+type schedule struct {
+	loc      *time.Location
+	interval time.Duration
+	jitter   time.Duration
+	windows  []window
+	crons    []cronExpr
+}
+
+type window struct {
+	days     [7]bool
+	from, to int // minutes since midnight, to <= from spans midnight
+	dates    []dateRange
+	interval time.Duration
+}
+
+type dateRange struct {
+	from, to string // YYYY-MM-DD, compared as strings
+}
+
+const defaultScheduleTimezone = "Europe/Berlin"
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Build a schedule, defaultInterval is used when the config has no interval
+func newSchedule(cfg scheduleConfig, defaultInterval time.Duration) (*schedule, error) {
+	var errs []error
+	s := &schedule{
+		interval: time.Duration(cfg.Interval),
+		jitter:   time.Duration(cfg.Jitter),
+	}
+	if s.interval == 0 {
+		s.interval = defaultInterval
+	}
+	if s.interval <= 0 {
+		errs = append(errs, errors.New("interval: must be positive"))
+	}
+	if s.jitter < 0 {
+		errs = append(errs, errors.New("jitter: must not be negative"))
+	}
+
+	timezone := cfg.Timezone
+	if timezone == "" {
+		timezone = defaultScheduleTimezone
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("timezone: unknown timezone %q", timezone))
+		loc = time.UTC
+	}
+	s.loc = loc
+
+	for i, windowCfg := range cfg.Windows {
+		w, err := parseWindow(windowCfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("windows[%d]: %w", i, err))
+			continue
+		}
+		s.windows = append(s.windows, w)
+	}
+	for i, expr := range cfg.Cron {
+		c, err := parseCron(expr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cron[%d]: %w", i, err))
+			continue
+		}
+		s.crons = append(s.crons, c)
+	}
+
+	return s, errors.Join(errs...)
+}
+
+func parseWindow(cfg windowConfig) (window, error) {
+	w := window{interval: time.Duration(cfg.Interval)}
+	if w.interval <= 0 {
+		return w, errors.New("interval: must be positive")
+	}
+
+	if cfg.Days == "" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	} else {
+		for _, part := range strings.Split(cfg.Days, ",") {
+			first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+			start := weekdayIndex(first)
+			end := start
+			if isRange {
+				end = weekdayIndex(last)
+			}
+			if start < 0 || end < 0 {
+				return w, fmt.Errorf("days: invalid weekday in %q, use e.g. mon-fri or sat,sun", cfg.Days)
+			}
+			for d := start; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == end {
+					break
+				}
+			}
+		}
+	}
+
+	var err error
+	if w.from, err = parseClock(cfg.From, 0); err != nil {
+		return w, fmt.Errorf("from: %w", err)
+	}
+	if w.to, err = parseClock(cfg.To, 24*60); err != nil {
+		return w, fmt.Errorf("to: %w", err)
+	}
+
+	for _, dates := range cfg.Dates {
+		from, to, isRange := strings.Cut(dates, "..")
+		if !isRange {
+			to = from
+		}
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		for _, date := range []string{from, to} {
+			if _, err := time.Parse("2006-01-02", date); err != nil {
+				return w, fmt.Errorf("dates: invalid date %q, use YYYY-MM-DD or YYYY-MM-DD..YYYY-MM-DD", date)
+			}
+		}
+		if to < from {
+			return w, fmt.Errorf("dates: %q ends before it starts", dates)
+		}
+		w.dates = append(w.dates, dateRange{from, to})
+	}
+	return w, nil
+}
+
+func weekdayIndex(name string) int {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, weekday := range weekdayNames {
+		if len(name) >= 3 && strings.HasPrefix(weekday, name[:3]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parse "HH:MM" into minutes since midnight
+func parseClock(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w window) matches(t time.Time) bool {
+	if len(w.dates) > 0 {
+		date := t.Format("2006-01-02")
+		inRange := false
+		for _, r := range w.dates {
+			if date >= r.from && date <= r.to {
+				inRange = true
+				break
+			}
+		}
+		if !inRange {
+			return false
+		}
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if w.from < w.to {
+		return w.days[t.Weekday()] && minute >= w.from && minute < w.to
+	}
+	// The window spans midnight, the part after midnight belongs to the previous day
+	if minute >= w.from {
+		return w.days[t.Weekday()]
+	}
+	return minute < w.to && w.days[(t.Weekday()+6)%7]
+}
+
+// Return the interval in effect at t, the first matching window wins
+func (s *schedule) intervalAt(t time.Time) time.Duration {
+	local := t.In(s.loc)
+	for _, w := range s.windows {
+		if w.matches(local) {
+			return w.interval
+		}
+	}
+	return s.interval
+}
+
+// Return when the next update is due after an update that started at last.
+// An update is due once the interval in effect has passed since last, or at
+// the next time matching a cron expression, whichever comes first.
+func (s *schedule) next(last time.Time) time.Time {
+	maxInterval := s.interval
+	for _, w := range s.windows {
+		maxInterval = max(maxInterval, w.interval)
+	}
+
+	// The interval only changes at full minutes, so check minute by minute
+	for minute := last.Truncate(time.Minute); minute.Before(last.Add(maxInterval + time.Minute)); minute = minute.Add(time.Minute) {
+		if minute.After(last) {
+			local := minute.In(s.loc)
+			for _, c := range s.crons {
+				if c.matches(local) {
+					return minute
+				}
+			}
+		}
+
+		due := last.Add(s.intervalAt(minute))
+		if due.Before(minute.Add(time.Minute)) {
+			if due.Before(minute) {
+				return minute
+			}
+			return due
+		}
+	}
+	return last.Add(maxInterval)
+}
+
+// Return the next update time with a random jitter added, so several
+// instances don't log in at exactly the same time
+func (s *schedule) nextWithJitter(last time.Time) time.Time {
+	next := s.next(last)
+	if s.jitter > 0 {
+		next = next.Add(rand.N(s.jitter))
+	}
+	return next
+}
+
+// cronExpr is a standard five field cron expression: minute, hour, day of
+// month, month and day of week. Fields support *, lists, ranges and steps.
+type cronExpr struct {
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool
+}
+
+func parseCron(expr string) (cronExpr, error) {
+	var c cronExpr
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return c, fmt.Errorf("%q must have 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return c, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return c, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return c, fmt.Errorf("day of month: %w", err)
+	}
+	months := []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	if c.month, err = parseCronField(fields[3], 1, 12, months); err != nil {
+		return c, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return c, fmt.Errorf("day of week: %w", err)
+	}
+	// Both 0 and 7 mean Sunday
+	c.dow[0] = c.dow[0] || c.dow[7]
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+func parseCronField(field string, min, max int, names []string) ([]bool, error) {
+	values := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = parseCronValue(first, names); err != nil {
+				return nil, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseCronValue(last, names); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func parseCronValue(value string, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(value, name) {
+			return i, nil
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return n, nil
+}
+
+func (c cronExpr) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[t.Month()] {
+		return false
+	}
+	// Like cron, if both day fields are restricted either may match
+	dom := c.dom[t.Day()]
+	dow := c.dow[t.Weekday()]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustSchedule(t *testing.T, cfg scheduleConfig) *schedule {
+	t.Helper()
+	s, err := newSchedule(cfg, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("newSchedule failed: %v", err)
+	}
+	return s
+}
+
+func berlinTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestScheduleWindows(t *testing.T) {
+	s := mustSchedule(t, scheduleConfig{
+		Windows: []windowConfig{
+			{Dates: []string{"2025-10-06..2025-10-10"}, Days: "mon-fri", From: "07:00", To: "22:00", Interval: duration(15 * time.Minute)},
+			{Days: "mon-fri", From: "07:00", To: "20:00", Interval: duration(30 * time.Minute)},
+			{Days: "fri", From: "23:00", To: "06:00", Interval: duration(6 * time.Hour)},
+		},
+	})
+
+	tests := []struct {
+		at   string
+		want time.Duration
+	}{
+		{"2025-10-07 10:00", 15 * time.Minute}, // semester start
+		{"2025-10-07 21:00", 15 * time.Minute},
+		{"2025-10-14 10:00", 30 * time.Minute},
+		{"2025-10-14 20:00", 2 * time.Hour}, // the end is exclusive
+		{"2025-10-14 06:59", 2 * time.Hour},
+		{"2025-10-18 10:00", 2 * time.Hour},    // Saturday
+		{"2025-10-17 23:30", 6 * time.Hour},    // Friday night
+		{"2025-10-18 05:59", 6 * time.Hour},    // still Friday night
+		{"2025-10-19 03:00", 2 * time.Hour},    // Saturday night
+		{"2025-10-11 10:00", 2 * time.Hour},    // after the date range
+		{"2025-10-10 21:59", 15 * time.Minute}, // last day of the date range
+	}
+	for _, tt := range tests {
+		if got := s.intervalAt(berlinTime(t, tt.at)); got != tt.want {
+			t.Errorf("intervalAt(%s) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	s := mustSchedule(t, scheduleConfig{
+		Windows: []windowConfig{
+			{Days: "mon-fri", From: "07:00", To: "20:00", Interval: duration(30 * time.Minute)},
+		},
+	})
+
+	tests := []struct {
+		last, want string
+	}{
+		{"2025-10-14 10:00", "2025-10-14 10:30"},
+		// The window starts before the default interval has passed
+		{"2025-10-14 06:00", "2025-10-14 07:00"},
+		// Outside of the window the default interval applies
+		{"2025-10-14 19:50", "2025-10-14 21:50"},
+		{"2025-10-18 10:00", "2025-10-18 12:00"},
+	}
+	for _, tt := range tests {
+		got := s.next(berlinTime(t, tt.last))
+		if want := berlinTime(t, tt.want); !got.Equal(want) {
+			t.Errorf("next(%s) = %s, want %s", tt.last, got.In(s.loc).Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestScheduleCron(t *testing.T) {
+	s := mustSchedule(t, scheduleConfig{
+		Interval: duration(6 * time.Hour),
+		Cron:     []string{"0 7 * * mon", "*/20 12-13 1 * *"},
+	})
+
+	tests := []struct {
+		last, want string
+	}{
+		{"2025-10-13 05:00", "2025-10-13 07:00"}, // Monday
+		{"2025-10-13 07:00", "2025-10-13 13:00"}, // not the same minute again
+		{"2025-10-14 05:00", "2025-10-14 11:00"},
+		{"2025-11-01 11:50", "2025-11-01 12:00"},
+		{"2025-11-01 12:00", "2025-11-01 12:20"},
+	}
+	for _, tt := range tests {
+		got := s.next(berlinTime(t, tt.last))
+		if want := berlinTime(t, tt.want); !got.Equal(want) {
+			t.Errorf("next(%s) = %s, want %s", tt.last, got.In(s.loc).Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestCronDayFields(t *testing.T) {
+	// With both day fields restricted either one matches, like cron
+	c, err := parseCron("0 8 1 * fri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for at, want := range map[string]bool{
+		"2025-10-01 08:00": true,  // the 1st, a Wednesday
+		"2025-10-03 08:00": true,  // a Friday
+		"2025-10-02 08:00": false, // neither
+		"2025-10-03 09:00": false,
+	} {
+		if got := c.matches(berlinTime(t, at)); got != want {
+			t.Errorf("matches(%s) = %v, want %v", at, got, want)
+		}
+	}
+
+	sunday, err := parseCron("0 0 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sunday.matches(berlinTime(t, "2025-10-19 00:00")) {
+		t.Error("day of week 7 should match Sunday")
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	s := mustSchedule(t, scheduleConfig{Interval: duration(time.Hour), Jitter: duration(10 * time.Minute)})
+	last := berlinTime(t, "2025-10-14 10:00")
+	for range 100 {
+		got := s.nextWithJitter(last)
+		if got.Before(last.Add(time.Hour)) || !got.Before(last.Add(70*time.Minute)) {
+			t.Fatalf("nextWithJitter = %s, want within 10m after 11:00", got.In(s.loc).Format("15:04:05"))
+		}
+	}
+}
+
+func TestScheduleValidation(t *testing.T) {
+	_, err := newSchedule(scheduleConfig{
+		Timezone: "Mars/Olympus",
+		Jitter:   duration(-time.Minute),
+		Windows: []windowConfig{
+			{Days: "mon-fry", Interval: duration(time.Hour)},
+			{From: "7am", Interval: duration(time.Hour)},
+			{Dates: []string{"2025-10-10..2025-10-01"}, Interval: duration(time.Hour)},
+			{Days: "mon"},
+		},
+		Cron: []string{"0 7 * *", "61 * * * *", "0 7 * * funday"},
+	}, time.Hour)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	for _, want := range []string{
+		"timezone: unknown timezone",
+		"jitter: must not be negative",
+		"windows[0]: days:",
+		"windows[1]: from: invalid time",
+		"windows[2]: dates:",
+		"windows[3]: interval: must be positive",
+		"cron[0]: \"0 7 * *\" must have 5 fields",
+		"cron[1]: minute:",
+		"cron[2]: day of week:",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestConfigScheduleErrors(t *testing.T) {
+	path := writeTestConfig(t, `
+schedule:
+  cron: ["bad"]
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+    schedule:
+      windows:
+        - days: mon
+`)
+	_, err := loadConfig(path, testEnv(nil))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"schedule.cron[0]:", "accounts[0].schedule.windows[0]: interval: must be positive"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
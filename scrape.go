@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// htmlTable is a table of a CampusNet page. Header holds the lower case
+// texts of the header cells, rows the cells of every other row.
+type htmlTable struct {
+	header []string
+	rows   [][]htmlCell
+}
+
+// htmlCell is the text of a cell, a line per <br>, and the links in it
+type htmlCell struct {
+	text  string
+	links []string
+}
+
+// Return the lines of the cell's text
+func (c htmlCell) lines() []string {
+	var lines []string
+	for _, line := range strings.Split(c.text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Parse every table of an HTML page. Nested tables are returned separately.
+func parseHTMLTables(body string) ([]htmlTable, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	var tables []htmlTable
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "table" {
+			tables = append(tables, parseHTMLTable(n))
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return tables, nil
+}
+
+func parseHTMLTable(table *html.Node) htmlTable {
+	var t htmlTable
+	var rows func(*html.Node)
+	rows = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "thead", "tbody", "tfoot":
+				rows(child)
+			case "tr":
+				var cells []htmlCell
+				header := false
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						header = header || cell.Data == "th"
+						cells = append(cells, parseHTMLCell(cell))
+					}
+				}
+				// The first row of header cells names the columns
+				if header && t.header == nil {
+					for _, cell := range cells {
+						t.header = append(t.header, strings.ToLower(strings.Join(cell.lines(), " ")))
+					}
+				} else if len(cells) > 0 {
+					t.rows = append(t.rows, cells)
+				}
+			}
+		}
+	}
+	rows(table)
+	return t
+}
+
+func parseHTMLCell(cell *html.Node) htmlCell {
+	var c htmlCell
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			b.WriteString("\n")
+		case n.Type == html.ElementNode && n.Data == "table":
+			// Nested tables are parsed on their own
+			return
+		case n.Type == html.ElementNode && n.Data == "a":
+			for _, attr := range n.Attr {
+				if attr.Key == "href" {
+					c.links = append(c.links, attr.Val)
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(cell)
+	// Collapse the whitespace of the markup but keep the line breaks
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	c.text = strings.TrimSpace(strings.Join(lines, "\n"))
+	return c
+}
+
+// Return the index of the first column whose header contains one of the
+// words, or -1
+func (t htmlTable) column(words ...string) int {
+	for i, header := range t.header {
+		for _, word := range words {
+			if strings.Contains(header, word) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Return the cell of the row in the column, empty if the row is shorter
+func cellAt(row []htmlCell, column int) htmlCell {
+	if column < 0 || column >= len(row) {
+		return htmlCell{}
+	}
+	return row[column]
+}
+
+var (
+	// Dates like "17.02.2025", "17. Feb. 2025" or "Mo, 17. Februar 2025"
+	tucanDatePattern = regexp.MustCompile(`(\d{1,2})\.\s*(?:(\d{1,2})\.|([A-Za-zäÄ]+)\.?)\s*(\d{4})`)
+	// Times like "09:00-11:00", "09:00 - 11:00 Uhr" or a single "09:00"
+	tucanTimePattern = regexp.MustCompile(`(\d{1,2}):(\d{2})(?:\s*(?:-|–|bis)\s*(\d{1,2}):(\d{2}))?`)
+)
+
+var germanMonths = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mär": time.March, "mrz": time.March, "mae": time.March, "mar": time.March,
+	"apr": time.April, "mai": time.May, "jun": time.June, "jul": time.July, "aug": time.August,
+	"sep": time.September, "okt": time.October, "nov": time.November, "dez": time.December,
+}
+
+var errNoDate = errors.New("no date")
+
+// Parse a date and time range as CampusNet writes them, e.g.
+// "Mo, 17. Feb. 2025 09:00-11:00". Without a time the date is a whole day and
+// start and end are midnight of that day and the next. A range ending before
+// it starts ends on the next day.
+func parseTucanTime(text string, loc *time.Location) (start, end time.Time, allDay bool, err error) {
+	m := tucanDatePattern.FindStringSubmatchIndex(text)
+	if m == nil {
+		return time.Time{}, time.Time{}, false, errNoDate
+	}
+	group := func(i int) string {
+		if m[2*i] < 0 {
+			return ""
+		}
+		return text[m[2*i]:m[2*i+1]]
+	}
+	day, _ := strconv.Atoi(group(1))
+	year, _ := strconv.Atoi(group(4))
+	var month time.Month
+	if number := group(2); number != "" {
+		n, _ := strconv.Atoi(number)
+		month = time.Month(n)
+	} else {
+		name := []rune(strings.ToLower(group(3)))
+		month = germanMonths[string(name[:min(3, len(name))])]
+	}
+	if month < time.January || month > time.December || day < 1 || day > 31 {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("invalid date %q", text[m[0]:m[1]])
+	}
+
+	date := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	t := tucanTimePattern.FindStringSubmatch(text[m[1]:])
+	if t == nil {
+		return date, date.AddDate(0, 0, 1), true, nil
+	}
+	clock := func(hour, minute string) time.Time {
+		h, _ := strconv.Atoi(hour)
+		mins, _ := strconv.Atoi(minute)
+		return time.Date(year, month, day, h, mins, 0, 0, loc)
+	}
+	start = clock(t[1], t[2])
+	end = start
+	if t[3] != "" {
+		end = clock(t[3], t[4])
+		if end.Before(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+	}
+	return start, end, false, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretValue is a setting given either directly or as a path to a file
+// containing it, like a Docker or Kubernetes secret mount. The file is read
+// again whenever it changes, so rotated secrets are picked up without a restart.
+type secretValue struct {
+	value string
+	path  string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	// Whether the file couldn't be read last time, to only log the change
+	failing bool
+}
+
+func newSecretValue(value, path string) *secretValue {
+	return &secretValue{value: value, path: path}
+}
+
+// Return the current value. If the file can't be read anymore the last value is kept.
+func (s *secretValue) get() string {
+	if s == nil {
+		return ""
+	}
+	if s.path == "" {
+		return s.value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		s.fail(err)
+		return s.value
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		s.recover()
+		return s.value
+	}
+
+	value, err := readSecretFile(s.path)
+	if err != nil {
+		s.fail(err)
+		return s.value
+	}
+	s.recover()
+	if !s.modTime.IsZero() {
+		log.Printf("Secret file %s changed, using the new value", s.path)
+	}
+	s.value = value
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return s.value
+}
+
+// Log that the file can't be read, once until it can be read again
+func (s *secretValue) fail(err error) {
+	if !s.failing {
+		log.Printf("Failed to read secret file %s, using the last value: %v", s.path, err)
+	}
+	s.failing = true
+}
+
+func (s *secretValue) recover() {
+	if s.failing {
+		log.Printf("Secret file %s can be read again", s.path)
+	}
+	s.failing = false
+}
+
+// Read a secret from a file, ignoring surrounding whitespace like the
+// trailing newline most editors add
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", errors.New(path + " is empty")
+	}
+	return value, nil
+}
+
+// secretList is a list of secrets like feed tokens, given directly or as a
+// file. Only a file is split into tokens, so configured ones may contain
+// commas.
+type secretList struct {
+	values []string
+	file   *secretValue
+}
+
+func newSecretList(values []string, path string) *secretList {
+	if path != "" {
+		return &secretList{file: newSecretValue("", path)}
+	}
+	l := &secretList{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			l.values = append(l.values, value)
+		}
+	}
+	return l
+}
+
+// Return the current secrets, reading the file again if it changed
+func (l *secretList) get() []string {
+	if l == nil {
+		return nil
+	}
+	if l.file != nil {
+		return splitSecretList(l.file.get())
+	}
+	return l.values
+}
+
+// Split a list of secrets separated by commas or newlines, as used for feed tokens
+func splitSecretList(list string) []string {
+	var values []string
+	for _, value := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
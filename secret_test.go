@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretValueRereadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := newSecretValue("", path)
+	if got := secret.get(); got != "first" {
+		t.Fatalf("expected %q, got %q", "first", got)
+	}
+
+	if err := os.WriteFile(path, []byte("second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := secret.get(); got != "second" {
+		t.Fatalf("expected the changed value %q, got %q", "second", got)
+	}
+
+	// A missing file keeps the last value instead of logging in with nothing,
+	// and is only logged once
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if got := secret.get(); got != "second" {
+			t.Fatalf("expected the last value %q, got %q", "second", got)
+		}
+	}
+	if n := strings.Count(logged.String(), "Failed to read secret file"); n != 1 {
+		t.Fatalf("expected the missing file to be logged once, got:\n%s", logged.String())
+	}
+}
+
+func TestSplitSecretList(t *testing.T) {
+	got := splitSecretList("old-token, new-token\nthird-token\n\n")
+	want := []string{"old-token", "new-token", "third-token"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
@@ -1,37 +1,634 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func runWebServer(port string) {
-	// Serve the merged calendar
-	http.HandleFunc("/tucan.ics", httpTucan)
-	http.HandleFunc("/health", httpHealth)
+// feedAuth describes how the calendar feed is protected. Several tokens can be
+// active at once so a new one can be handed out before the old one is removed.
+type feedAuth struct {
+	tokens        *secretList
+	basicUser     *secretValue
+	basicPassword *secretValue
+}
+
+func (a feedAuth) enabled() bool {
+	return len(a.tokenList()) > 0 || a.basicAuthEnabled()
+}
 
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+func (a feedAuth) basicAuthEnabled() bool {
+	return a.basicUser.get() != "" && a.basicPassword.get() != ""
 }
 
-// Serve the merged calendar at /tucan.ics
-func httpTucan(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/calendar")
-	data, err := os.ReadFile(icalFile)
+func (a feedAuth) tokenList() []string {
+	return a.tokens.get()
+}
+
+func (a feedAuth) validToken(token string) bool {
+	valid := false
+	for _, t := range a.tokenList() {
+		// Compare against every token so the timing doesn't leak which one matched
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (a feedAuth) validBasicAuth(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.basicUser.get())) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(a.basicPassword.get())) == 1
+	return userOK && passwordOK
+}
+
+func runWebServer(cfg config, accounts []*account) {
+	// The bare path can only serve a single account
+	if len(accounts) == 1 {
+		acc := accounts[0]
+		if !acc.auth.enabled() {
+			log.Println("Warning: no feed tokens or basic auth are set, the calendar is public at /tucan.ics")
+		}
+		http.HandleFunc("/tucan.ics", requireBarePathAuth(acc.auth, httpTucan(acc)))
+		http.HandleFunc("GET /status", requireBarePathAuth(acc.auth, httpStatus(accounts)))
+	}
+
+	// Serve each account's merged calendar behind its secret tokens
+	http.HandleFunc("GET /feed/{file}", httpFeed(accounts))
+	http.HandleFunc("/health", httpHealth(accounts))
+
+	// Trigger and poll updates, authenticated with an admin or API token
+	adminTokens := cfg.adminTokens()
+	http.HandleFunc("POST /admin/refresh", httpRefresh(accounts, adminTokens))
+	http.HandleFunc("GET /admin/refresh/{id}", httpRefreshJob(accounts, adminTokens))
+	http.HandleFunc("GET /api/changes", httpChanges(accounts, adminTokens))
+	http.HandleFunc("GET /api/deliveries", httpDeliveries(accounts, adminTokens))
+	http.HandleFunc("GET /api/events", httpEvents(accounts, adminTokens))
+	http.HandleFunc("GET /api/conflicts", httpConflicts(accounts, adminTokens))
+	http.HandleFunc("GET /status/{token}", httpStatusToken(accounts, adminTokens))
+
+	log.Fatal(http.ListenAndServe(":"+cfg.Port, nil))
+}
+
+// Serve the merged calendar at /tucan.ics. ?alarm=15m,1h adds alarms to
+// every event, ?compact= and ?rooms= override those settings with true or
+// false, ?conflicts= the way conflicts are flagged and ?type=, ?course= and
+// ?group= only keep the matching events.
+func httpTucan(acc *account) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := os.ReadFile(acc.icalPath())
+		if err != nil {
+			http.Error(w, "Failed to read calendar file", http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+		var alarms []alarmRule
+		if values := query["alarm"]; len(values) > 0 {
+			if alarms, err = parseAlarmQuery(values); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		compact, err := boolParam(query, "compact", acc.calendar.Compact)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rooms, err := boolParam(query, "rooms", acc.calendar.Rooms)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		flag := acc.conflicts.Flag
+		if value := query.Get("conflicts"); value != "" {
+			if value != flagCategory && value != flagPrefix && value != flagOff {
+				http.Error(w, "conflicts must be category, prefix or off", http.StatusBadRequest)
+				return
+			}
+			flag = value
+		}
+		flagging := flag == flagCategory || flag == flagPrefix
+
+		filter := parseEventFilter(query)
+
+		// The stored calendar keeps every event and the rooms as TUCaN names
+		// them, compacting and enriching only change what is served
+		if len(alarms) > 0 || compact || rooms || flagging || !filter.empty() {
+			cal, err := parseICalendar(string(data))
+			if err != nil {
+				http.Error(w, "Failed to parse calendar file", http.StatusInternalServerError)
+				return
+			}
+			// Conflicts with filtered out events still count
+			if flagging {
+				conflicts := findConflicts(calendarEvents(cal), acc.rooms, time.Duration(acc.conflicts.TravelTime))
+				flagConflicts(cal, conflicts, flag, acc.conflicts.label(flag))
+			}
+			filterEvents(cal, filter)
+			if rooms {
+				enrichLocations(cal, acc.rooms)
+			}
+			addAlarms(cal, alarms)
+			if compact {
+				compactEvents(cal)
+			}
+			data = []byte(cal.serialize())
+		}
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Write(data)
+	}
+}
+
+// Read a true or false query parameter, or the setting if it isn't given
+func boolParam(query url.Values, name string, setting *bool) (bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return setting != nil && *setting, nil
+	}
+	enabled, err := strconv.ParseBool(value)
 	if err != nil {
-		http.Error(w, "Failed to read calendar file", http.StatusInternalServerError)
-		return
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return enabled, nil
+}
+
+// Serve the merged calendar of the account owning the token at /feed/{token}.ics
+func httpFeed(accounts []*account) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutSuffix(r.PathValue("file"), ".ics")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		acc := accountForToken(accounts, token)
+		if acc == nil {
+			http.NotFound(w, r)
+			return
+		}
+		httpTucan(acc)(w, r)
+	}
+}
+
+func accountForToken(accounts []*account, token string) *account {
+	for _, acc := range accounts {
+		if acc.auth.validToken(token) {
+			return acc
+		}
+	}
+	return nil
+}
+
+func requireBasicAuth(auth feedAuth, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auth.validBasicAuth(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tucan-ical", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Guard the bare paths of a single account. They are public without feed
+// tokens and basic auth, behind basic auth if it is set and disabled if only
+// feed tokens are set. The secrets may be files that change, so this is
+// decided on every request.
+func requireBarePathAuth(auth feedAuth, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case auth.basicAuthEnabled():
+			requireBasicAuth(auth, next)(w, r)
+		case auth.enabled():
+			http.NotFound(w, r)
+		default:
+			next(w, r)
+		}
+	}
+}
+
+// Return the accounts the bearer token may manage. An admin token may manage
+// every account, an API token only its own account. Feed tokens are part of
+// the subscription URLs handed to calendar apps, so they aren't accepted.
+func authorizedAccounts(r *http.Request, accounts []*account, adminTokens *secretList) []*account {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil
+	}
+	if (feedAuth{tokens: adminTokens}).validToken(token) {
+		return accounts
+	}
+	for _, acc := range accounts {
+		if (feedAuth{tokens: acc.apiTokens}).validToken(token) {
+			return []*account{acc}
+		}
+	}
+	return nil
+}
+
+// Return every account for an admin token, the account owning a feed token,
+// or nil
+func tokenAccounts(token string, accounts []*account, adminTokens *secretList) []*account {
+	if token == "" {
+		return nil
+	}
+	if (feedAuth{tokens: adminTokens}).validToken(token) {
+		return accounts
+	}
+	if acc := accountForToken(accounts, token); acc != nil {
+		return []*account{acc}
+	}
+	return nil
+}
+
+// Limit the accounts to the one named by ?account=, if given
+func selectAccount(accounts []*account, name string) []*account {
+	if name == "" {
+		return accounts
+	}
+	for _, acc := range accounts {
+		if acc.name == name {
+			return []*account{acc}
+		}
 	}
-	w.Write(data)
+	return nil
+}
+
+type refreshResponse struct {
+	Jobs   []refreshJob      `json:"jobs"`
+	Errors map[string]string `json:"errors,omitempty"`
 }
 
-// Health check endpoint
-func httpHealth(w http.ResponseWriter, r *http.Request) {
-	if !lastNewestCalendarGetOK.Load() {
-		http.Error(w, "NOT OK", http.StatusServiceUnavailable)
-		return
+// Request an immediate update at POST /admin/refresh. ?account=name limits it
+// to one account and ?wait=true waits for the result instead of returning the
+// job IDs to poll at /admin/refresh/{id}.
+func httpRefresh(accounts []*account, adminTokens *secretList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := authorizedAccounts(r, accounts, adminTokens)
+		if allowed == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tucan-ical"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		allowed = selectAccount(allowed, r.URL.Query().Get("account"))
+		if allowed == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		type requestedJob struct {
+			acc *account
+			job *refreshJob
+		}
+		var response refreshResponse
+		var jobs []requestedJob
+		var retryAfter time.Duration
+		for _, acc := range allowed {
+			job, err := acc.refresh.request(acc.name, "http")
+			if err != nil {
+				if response.Errors == nil {
+					response.Errors = make(map[string]string)
+				}
+				response.Errors[acc.name] = err.Error()
+				var tooSoon errRefreshTooSoon
+				if errors.As(err, &tooSoon) && (retryAfter == 0 || tooSoon.retryAfter < retryAfter) {
+					retryAfter = tooSoon.retryAfter
+				}
+				continue
+			}
+			jobs = append(jobs, requestedJob{acc, job})
+		}
+
+		wait, _ := strconv.ParseBool(r.URL.Query().Get("wait"))
+		status := http.StatusAccepted
+		if wait {
+			status = http.StatusOK
+			for _, requested := range jobs {
+				select {
+				case <-requested.job.done:
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}
+		for i, requested := range jobs {
+			response.Jobs = append(response.Jobs, requested.acc.refresh.snapshot(requested.job))
+			if i == 0 {
+				w.Header().Set("Location", "/admin/refresh/"+requested.job.ID)
+			}
+		}
+
+		if len(jobs) == 0 {
+			status = http.StatusConflict
+			if retryAfter > 0 {
+				status = http.StatusTooManyRequests
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			}
+		}
+		writeJSON(w, status, response)
 	}
+}
+
+// Poll a refresh job at GET /admin/refresh/{id}
+func httpRefreshJob(accounts []*account, adminTokens *secretList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := authorizedAccounts(r, accounts, adminTokens)
+		if allowed == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tucan-ical"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		for _, acc := range allowed {
+			if job, ok := acc.refresh.job(r.PathValue("id")); ok {
+				writeJSON(w, http.StatusOK, job)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// List the calendar changes at GET /api/changes as JSON, or as a changelog with
+// ?format=text or ?format=markdown. ?account=name limits it to one account and
+// ?since= (RFC 3339) to newer changes.
+func httpChanges(accounts []*account, adminTokens *secretList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := authorizedAccounts(r, accounts, adminTokens)
+		if allowed == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tucan-ical"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		allowed = selectAccount(allowed, r.URL.Query().Get("account"))
+		if allowed == nil {
+			http.NotFound(w, r)
+			return
+		}
+		var since time.Time
+		if value := r.URL.Query().Get("since"); value != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+		}
+
+		sets := []changeSet{}
+		for _, acc := range allowed {
+			accountSets, err := acc.changes.since(since)
+			if err != nil {
+				acc.log.Printf("Failed to read changes: %v", err)
+				http.Error(w, "Failed to read changes", http.StatusInternalServerError)
+				return
+			}
+			sets = append(sets, accountSets...)
+		}
+		sort.SliceStable(sets, func(i, j int) bool {
+			return sets[i].Time.Before(sets[j].Time)
+		})
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			writeJSON(w, http.StatusOK, sets)
+		case "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writeChangelog(w, sets, false)
+		case "markdown", "md":
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			writeChangelog(w, sets, true)
+		default:
+			http.Error(w, "format must be json, text or markdown", http.StatusBadRequest)
+		}
+	}
+}
+
+// List the latest webhook deliveries at GET /api/deliveries, ?account=name
+// limits it to one account
+func httpDeliveries(accounts []*account, adminTokens *secretList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := authorizedAccounts(r, accounts, adminTokens)
+		if allowed == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tucan-ical"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		allowed = selectAccount(allowed, r.URL.Query().Get("account"))
+		if allowed == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		response := make(map[string][]delivery)
+		for _, acc := range allowed {
+			deliveries, err := acc.deliveries.list()
+			if err != nil {
+				acc.log.Printf("Failed to read the delivery log: %v", err)
+				http.Error(w, "Failed to read the delivery log", http.StatusInternalServerError)
+				return
+			}
+			response[acc.name] = deliveries
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// apiEvent is an event as listed by GET /api/events
+type apiEvent struct {
+	Account  string     `json:"account"`
+	UID      string     `json:"uid,omitempty"`
+	Summary  string     `json:"summary"`
+	Location string     `json:"location,omitempty"`
+	Start    time.Time  `json:"start"`
+	End      time.Time  `json:"end,omitzero"`
+	Course   courseInfo `json:"course"`
+}
+
+// List the events with their course information at GET /api/events.
+// ?from= and ?to= (RFC 3339 or YYYY-MM-DD) limit the time range, ?type=,
+// ?course= and ?group= filter like on the feed.
+func httpEvents(accounts []*account, adminTokens *secretList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := authorizedAccounts(r, accounts, adminTokens)
+		if allowed == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tucan-ical"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		query := r.URL.Query()
+		allowed = selectAccount(allowed, query.Get("account"))
+		if allowed == nil {
+			http.NotFound(w, r)
+			return
+		}
+		from, to, err := parseTimeRange(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter := parseEventFilter(query)
+
+		events := []apiEvent{}
+		for _, acc := range allowed {
+			cal, err := acc.readCalendar()
+			if err != nil {
+				acc.log.Printf("Failed to read the calendar: %v", err)
+				http.Error(w, "Failed to read calendar file", http.StatusInternalServerError)
+				return
+			}
+			for _, event := range calendarEvents(cal) {
+				if (!from.IsZero() && eventEnd(event).Before(from)) || (!to.IsZero() && !event.Start.Before(to)) || !filter.matches(event.Course) {
+					continue
+				}
+				events = append(events, newAPIEvent(acc, event))
+			}
+		}
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].Start.Before(events[j].Start)
+		})
+		writeJSON(w, http.StatusOK, events)
+	}
+}
+
+func newAPIEvent(acc *account, event calendarEvent) apiEvent {
+	return apiEvent{
+		Account:  acc.name,
+		UID:      event.UID,
+		Summary:  event.Summary,
+		Location: event.Location,
+		Start:    event.Start,
+		End:      event.End,
+		Course:   event.Course,
+	}
+}
+
+// apiConflict is a pair of conflicting events. Start and End are the overlap,
+// or for a travel conflict the gap between the events.
+type apiConflict struct {
+	Account string      `json:"account"`
+	Events  [2]apiEvent `json:"events"`
+	Start   time.Time   `json:"start"`
+	End     time.Time   `json:"end"`
+	Travel  bool        `json:"travel,omitempty"`
+}
+
+// List the conflicts between the events of the accounts the token may see at
+// /api/conflicts, by default those that haven't ended yet
+func httpConflicts(accounts []*account, adminTokens *secretList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := authorizedAccounts(r, accounts, adminTokens)
+		if allowed == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tucan-ical"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		query := r.URL.Query()
+		allowed = selectAccount(allowed, query.Get("account"))
+		if allowed == nil {
+			http.NotFound(w, r)
+			return
+		}
+		from, to, err := parseTimeRange(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if query.Get("from") == "" {
+			from = time.Now()
+		}
+
+		conflicts := []apiConflict{}
+		for _, acc := range allowed {
+			found, err := acc.conflictsBetween(from, to)
+			if err != nil {
+				acc.log.Printf("Failed to read the calendar: %v", err)
+				http.Error(w, "Failed to read calendar file", http.StatusInternalServerError)
+				return
+			}
+			for _, c := range found {
+				conflicts = append(conflicts, apiConflict{
+					Account: acc.name,
+					Events:  [2]apiEvent{newAPIEvent(acc, c.First), newAPIEvent(acc, c.Second)},
+					Start:   c.Start,
+					End:     c.End,
+					Travel:  c.Travel,
+				})
+			}
+		}
+		sort.SliceStable(conflicts, func(i, j int) bool {
+			return conflicts[i].Start.Before(conflicts[j].Start)
+		})
+		writeJSON(w, http.StatusOK, conflicts)
+	}
+}
+
+// Read the from and to query parameters, either may be left out
+func parseTimeRange(query url.Values) (from, to time.Time, err error) {
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		if *param.value, err = parseTimeParam(value); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%s must be an RFC 3339 time or a date", param.name)
+		}
+	}
+	return from, to, nil
+}
+
+// Parse an RFC 3339 time or a date, which is midnight in Europe/Berlin
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, berlin)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Failed to write JSON response: %v", err)
+	}
+}
+
+// Health check endpoint, fails as soon as any account is failing
+func httpHealth(accounts []*account) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		healthy := true
+		for _, acc := range accounts {
+			healthy = healthy && acc.lastNewestCalendarGetOK.Load()
+		}
+
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		w.Write([]byte(okText(healthy)))
+	}
+}
+
+func okText(ok bool) string {
+	if ok {
+		return "OK"
+	}
+	return "NOT OK"
 }
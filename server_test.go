@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFeedAuthValidToken(t *testing.T) {
+	auth := feedAuth{tokens: newSecretList([]string{"old-token-0123456789", "new-token-0123456789"}, "")}
+
+	if !auth.validToken("old-token-0123456789") || !auth.validToken("new-token-0123456789") {
+		t.Fatal("expected both rotated tokens to be accepted")
+	}
+	if auth.validToken("") || auth.validToken("new-token") {
+		t.Fatal("expected unknown tokens to be rejected")
+	}
+}
+
+func TestHttpFeedRejectsUnknownToken(t *testing.T) {
+	acc := newAccount("test", t.TempDir())
+	acc.auth = feedAuth{tokens: newSecretList([]string{"secret-token-0123456789"}, "")}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /feed/{file}", httpFeed([]*account{acc}))
+
+	for _, path := range []string{"/feed/wrong.ics", "/feed/secret-token-0123456789", "/feed/.ics"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("GET %s = %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestRequireBasicAuth(t *testing.T) {
+	auth := feedAuth{basicUser: newSecretValue("student", ""), basicPassword: newSecretValue("hunter2", "")}
+	handler := requireBasicAuth(auth, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/tucan.ics", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", rec.Code)
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("expected WWW-Authenticate header")
+	}
+
+	req.SetBasicAuth("student", "hunter2")
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 with credentials, got %d", rec.Code)
+	}
+}
+
+func TestBarePathFollowsChangingTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feed_tokens")
+	if err := os.WriteFile(path, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth := feedAuth{tokens: newSecretList(nil, path)}
+	handler := requireBarePathAuth(auth, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	get := func() int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/tucan.ics", nil))
+		return rec.Code
+	}
+
+	// An empty file means no tokens, the calendar is public
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("expected the public calendar, got %d", code)
+	}
+	if err := os.WriteFile(path, []byte("secret-token-0123456789\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if code := get(); code != http.StatusNotFound {
+		t.Fatalf("expected the bare path to be disabled once a token is set, got %d", code)
+	}
+}
+
+func TestFeedTokensMayContainCommas(t *testing.T) {
+	auth := feedAuth{tokens: newSecretList([]string{"part-one,part-two-0123456789"}, "")}
+	if !auth.validToken("part-one,part-two-0123456789") || auth.validToken("part-one") {
+		t.Fatal("expected the token to be kept in one piece")
+	}
+}
+
+func TestHttpHealthFailsForAnyAccount(t *testing.T) {
+	alice, bob := newAccount("alice", t.TempDir()), newAccount("bob", t.TempDir())
+	handler := httpHealth([]*account{alice, bob})
+	alice.lastNewestCalendarGetOK.Store(true)
+
+	for _, bobOK := range []bool{false, true} {
+		bob.lastNewestCalendarGetOK.Store(bobOK)
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/health", nil))
+		want := http.StatusServiceUnavailable
+		if bobOK {
+			want = http.StatusOK
+		}
+		if rec.Code != want {
+			t.Fatalf("bob ok %v: got %d, want %d:\n%s", bobOK, rec.Code, want, rec.Body)
+		}
+		// The health check is public, it doesn't list the accounts
+		if body := rec.Body.String(); body != okText(bobOK) {
+			t.Fatalf("bob ok %v: got body %q, want %q", bobOK, body, okText(bobOK))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// accountStatus is what the status page shows about an account
+type accountStatus struct {
+	Name       string
+	OK         bool
+	Updated    time.Time
+	Upcoming   int
+	LastChange time.Time
+	Conflicts  []conflict
+	Sources    []monthSource
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"formatTime": func(t time.Time) string {
+		return t.In(berlin).Format("Mon 02.01.2006 15:04")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>tucan-ical status</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; }
+th { text-align: left; padding-right: 1em; }
+.failing { color: #b00; }
+</style>
+</head>
+<body>
+<h1>tucan-ical status</h1>
+{{range .}}
+<h2>{{.Name}}</h2>
+<table>
+<tr><th>Export</th><td>{{if .OK}}OK{{else}}<span class="failing">failing</span>{{end}}</td></tr>
+<tr><th>Last update</th><td>{{if .Updated.IsZero}}never{{else}}{{formatTime .Updated}}{{end}}</td></tr>
+<tr><th>Upcoming events</th><td>{{.Upcoming}}</td></tr>
+<tr><th>Last change</th><td>{{if .LastChange.IsZero}}none{{else}}{{formatTime .LastChange}}{{end}}</td></tr>
+</table>
+{{with .Sources}}<h3>Months</h3>
+<table>
+{{range .}}<tr><th>{{.Month}}</th><td>{{if eq .Source "export"}}{{.Source}}{{else}}<span class="failing">{{.Source}}</span>{{end}}</td></tr>
+{{end}}</table>{{end}}
+<h3>Conflicts</h3>
+{{with .Conflicts}}<ul>
+{{range .}}<li>{{.}}</li>
+{{end}}</ul>{{else}}<p>No upcoming conflicts.</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// Collect the status of the account at now
+func (a *account) status(now time.Time) (accountStatus, error) {
+	status := accountStatus{Name: a.name, OK: a.lastNewestCalendarGetOK.Load(), Sources: a.monthSources()}
+	if info, err := os.Stat(a.icalPath()); err == nil {
+		status.Updated = info.ModTime()
+	}
+	cal, err := a.readCalendar()
+	if err != nil {
+		return status, err
+	}
+	for _, event := range calendarEvents(cal) {
+		if eventEnd(event).After(now) {
+			status.Upcoming++
+		}
+	}
+	if sets, err := a.changes.since(time.Time{}); err == nil && len(sets) > 0 {
+		status.LastChange = sets[len(sets)-1].Time
+	}
+	if status.Conflicts, err = a.conflictsBetween(now, time.Time{}); err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+// Render the status page of the accounts
+func httpStatus(accounts []*account) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		var statuses []accountStatus
+		for _, acc := range accounts {
+			status, err := acc.status(now)
+			if err != nil {
+				acc.log.Printf("Failed to read the calendar: %v", err)
+				http.Error(w, "Failed to read calendar file", http.StatusInternalServerError)
+				return
+			}
+			statuses = append(statuses, status)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, statuses); err != nil {
+			accounts[0].log.Printf("Failed to render the status page: %v", err)
+		}
+	}
+}
+
+// Serve the status page at /status/{token}, an admin token shows every account
+func httpStatusToken(accounts []*account, adminTokens *secretList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := tokenAccounts(strings.TrimSpace(r.PathValue("token")), accounts, adminTokens)
+		if allowed == nil {
+			http.NotFound(w, r)
+			return
+		}
+		httpStatus(allowed)(w, r)
+	}
+}
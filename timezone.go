@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	// Embed the timezone database, the Alpine image doesn't ship one
+	_ "time/tzdata"
+)
+
+// berlin is changeTimeZone, loaded once. The embedded database always has it.
+var berlin = func() *time.Location {
+	loc, err := time.LoadLocation(changeTimeZone)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}()
+
+// The VTIMEZONE for changeTimeZone. Germany has switched on the last Sundays
+// of March and October since 1996, which covers every semester TUCaN exports.
+var berlinVTimezone = &icalComponent{
+	name: "VTIMEZONE",
+	properties: []*icalProperty{
+		{name: "TZID", value: changeTimeZone},
+		{name: "X-LIC-LOCATION", value: changeTimeZone},
+	},
+	components: []*icalComponent{
+		{name: "DAYLIGHT", properties: []*icalProperty{
+			{name: "TZOFFSETFROM", value: "+0100"},
+			{name: "TZOFFSETTO", value: "+0200"},
+			{name: "TZNAME", value: "CEST"},
+			{name: "DTSTART", value: "19700329T020000"},
+			{name: "RRULE", value: "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU"},
+		}},
+		{name: "STANDARD", properties: []*icalProperty{
+			{name: "TZOFFSETFROM", value: "+0200"},
+			{name: "TZOFFSETTO", value: "+0100"},
+			{name: "TZNAME", value: "CET"},
+			{name: "DTSTART", value: "19701025T030000"},
+			{name: "RRULE", value: "FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU"},
+		}},
+	},
+}
+
+// Event properties holding date-times, EXDATE and RDATE may list several
+var dateTimeProperties = map[string]bool{"DTSTART": true, "DTEND": true, "RECURRENCE-ID": true, "EXDATE": true, "RDATE": true}
+
+// Rewrite every date-time of the events to UTC, or to TZID=Europe/Berlin with
+// one generated VTIMEZONE. Floating times are taken as Europe/Berlin. Times
+// that can't be parsed are left alone together with the first VTIMEZONE of
+// their TZID, or the generated one for Europe/Berlin.
+func normalizeTimes(cal *icalComponent, utc bool) {
+	keepZones := make(map[string]bool)
+	usesBerlin := false
+
+	for _, event := range cal.children("VEVENT") {
+		for _, prop := range event.properties {
+			if !dateTimeProperties[prop.name] || prop.params["VALUE"] == "DATE" || prop.params["VALUE"] == "PERIOD" {
+				continue
+			}
+			var times []time.Time
+			ok := true
+			for _, value := range strings.Split(prop.value, ",") {
+				t, err := parseICalTime(&icalProperty{params: prop.params, value: value}, berlin)
+				// A date without VALUE=DATE stays as it is
+				if err != nil || len(value) == 8 {
+					ok = false
+					break
+				}
+				times = append(times, t)
+			}
+			if !ok {
+				if tzid := prop.params["TZID"]; tzid == changeTimeZone {
+					usesBerlin = true
+				} else if tzid != "" {
+					keepZones[tzid] = true
+				}
+				continue
+			}
+
+			// A property with several values needs all of them in the same form
+			local := !utc
+			for _, t := range times {
+				local = local && !ambiguousLocalTime(t, berlin)
+			}
+			values := make([]string, len(times))
+			for i, t := range times {
+				if local {
+					values[i] = t.In(berlin).Format("20060102T150405")
+				} else {
+					values[i] = t.UTC().Format("20060102T150405Z")
+				}
+			}
+			delete(prop.params, "TZID")
+			if local {
+				prop.params["TZID"] = changeTimeZone
+				usesBerlin = true
+			}
+			prop.value = strings.Join(values, ",")
+		}
+	}
+
+	components := make([]*icalComponent, 0, len(cal.components)+1)
+	if usesBerlin {
+		components = append(components, berlinVTimezone)
+	}
+	// Every merged month brings its own copy of the zones
+	for _, component := range cal.components {
+		if component.name == "VTIMEZONE" {
+			tzid := component.value("TZID")
+			if !keepZones[tzid] {
+				continue
+			}
+			delete(keepZones, tzid)
+		}
+		components = append(components, component)
+	}
+	cal.components = components
+}
+
+// Return whether the local time of t doesn't identify it, because it falls
+// into the hour that is repeated when the clocks go back in October
+func ambiguousLocalTime(t time.Time, loc *time.Location) bool {
+	wall := t.In(loc).Format("20060102T150405")
+	return t.Add(-time.Hour).In(loc).Format("20060102T150405") == wall ||
+		t.Add(time.Hour).In(loc).Format("20060102T150405") == wall
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Two monthly exports, each with its own copy of the VTIMEZONE and times in
+// every representation TUCaN and clients produce
+const timezoneTestCalendar = "BEGIN:VCALENDAR\r\n" +
+	"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\nEND:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\nUID:floating-before\r\nDTSTART:20250329T081500\r\nDTEND:20250329T095500\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:tzid-after\r\nDTSTART;TZID=Europe/Berlin:20250331T081500\r\nDTEND;TZID=Europe/Berlin:20250331T095500\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:utc-switch-day\r\nDTSTART:20250330T061500Z\r\nDTEND:20250330T075500Z\r\nEND:VEVENT\r\n" +
+	"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\nEND:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\nUID:october-before\r\nDTSTART;TZID=Europe/Berlin:20251025T081500\r\nDTEND;TZID=Europe/Berlin:20251025T095500\r\n" +
+	"EXDATE;TZID=Europe/Berlin:20251018T081500,20251025T081500\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:october-after\r\nDTSTART:20251027T071500Z\r\nDTEND:20251027T085500Z\r\nEND:VEVENT\r\n" +
+	// 02:30 happens twice on October 26th, at 00:30 and 01:30 UTC
+	"BEGIN:VEVENT\r\nUID:repeated-hour\r\nDTSTART:20251026T003000Z\r\nDTEND:20251026T013000Z\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:all-day\r\nDTSTART;VALUE=DATE:20251026\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func normalizedTestCalendar(t *testing.T, utc bool) *icalComponent {
+	t.Helper()
+	cal, err := parseICalendar(timezoneTestCalendar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalizeTimes(cal, utc)
+	// The result must parse again
+	cal, err = parseICalendar(cal.serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cal
+}
+
+func eventByUID(t *testing.T, cal *icalComponent, uid string) *icalComponent {
+	t.Helper()
+	for _, event := range cal.children("VEVENT") {
+		if event.value("UID") == uid {
+			return event
+		}
+	}
+	t.Fatalf("no event %s", uid)
+	return nil
+}
+
+func TestNormalizeTimesLocal(t *testing.T) {
+	cal := normalizedTestCalendar(t, false)
+
+	if zones := cal.children("VTIMEZONE"); len(zones) != 1 || zones[0].value("TZID") != "Europe/Berlin" || len(zones[0].components) != 2 {
+		t.Fatalf("expected exactly the generated VTIMEZONE, got %d", len(zones))
+	}
+	if cal.components[0].name != "VTIMEZONE" {
+		t.Fatal("the VTIMEZONE should come before the events")
+	}
+
+	tests := []struct {
+		uid, prop, want string
+		utc             string
+	}{
+		// March 30th 2025 02:00 CET becomes 03:00 CEST
+		{"floating-before", "DTSTART", "DTSTART;TZID=Europe/Berlin:20250329T081500", "2025-03-29 07:15"},
+		{"utc-switch-day", "DTSTART", "DTSTART;TZID=Europe/Berlin:20250330T081500", "2025-03-30 06:15"},
+		{"tzid-after", "DTEND", "DTEND;TZID=Europe/Berlin:20250331T095500", "2025-03-31 07:55"},
+		// October 26th 2025 03:00 CEST becomes 02:00 CET
+		{"october-before", "DTSTART", "DTSTART;TZID=Europe/Berlin:20251025T081500", "2025-10-25 06:15"},
+		{"october-after", "DTSTART", "DTSTART;TZID=Europe/Berlin:20251027T081500", "2025-10-27 07:15"},
+		// Local times in the repeated hour would be ambiguous
+		{"repeated-hour", "DTSTART", "DTSTART:20251026T003000Z", "2025-10-26 00:30"},
+		{"repeated-hour", "DTEND", "DTEND:20251026T013000Z", "2025-10-26 01:30"},
+	}
+	for _, tt := range tests {
+		prop := eventByUID(t, cal, tt.uid).property(tt.prop)
+		if prop.String() != tt.want {
+			t.Errorf("%s %s = %s, want %s", tt.uid, tt.prop, prop, tt.want)
+		}
+		got, err := parseICalTime(prop, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.UTC().Format("2006-01-02 15:04") != tt.utc {
+			t.Errorf("%s %s is %v, want %s UTC", tt.uid, tt.prop, got.UTC(), tt.utc)
+		}
+	}
+
+	if exdate := eventByUID(t, cal, "october-before").property("EXDATE").String(); exdate != "EXDATE;TZID=Europe/Berlin:20251018T081500,20251025T081500" {
+		t.Errorf("unexpected EXDATE %s", exdate)
+	}
+	if allDay := eventByUID(t, cal, "all-day").property("DTSTART").String(); allDay != "DTSTART;VALUE=DATE:20251026" {
+		t.Errorf("all-day event changed to %s", allDay)
+	}
+}
+
+func TestNormalizeTimesUTC(t *testing.T) {
+	cal := normalizedTestCalendar(t, true)
+	if len(cal.children("VTIMEZONE")) != 0 {
+		t.Fatal("UTC times need no VTIMEZONE")
+	}
+	tests := map[string]string{
+		"floating-before": "DTSTART:20250329T071500Z",
+		"utc-switch-day":  "DTSTART:20250330T061500Z",
+		"tzid-after":      "DTSTART:20250331T061500Z",
+		"october-before":  "DTSTART:20251025T061500Z",
+		"october-after":   "DTSTART:20251027T071500Z",
+	}
+	for uid, want := range tests {
+		if got := eventByUID(t, cal, uid).property("DTSTART").String(); got != want {
+			t.Errorf("%s: %s, want %s", uid, got, want)
+		}
+	}
+	if exdate := eventByUID(t, cal, "october-before").property("EXDATE").String(); exdate != "EXDATE:20251018T061500Z,20251025T061500Z" {
+		t.Errorf("unexpected EXDATE %s", exdate)
+	}
+}
+
+func TestNormalizeTimesKeepsUnknownZones(t *testing.T) {
+	// Every merged month has its own copy of the zones
+	month := "BEGIN:VTIMEZONE\r\nTZID:W. Europe Standard Time\r\nEND:VTIMEZONE\r\n" +
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\nX-EXPORTED:TRUE\r\nEND:VTIMEZONE\r\n"
+	cal, err := parseICalendar("BEGIN:VCALENDAR\r\n" + month + month +
+		"BEGIN:VEVENT\r\nUID:1\r\nDTSTART;TZID=W. Europe Standard Time:20251020T081500\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:2\r\nDTSTART;TZID=Europe/Berlin:20251020T0815\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalizeTimes(cal, false)
+	zones := cal.children("VTIMEZONE")
+	if len(zones) != 2 || zones[0] != berlinVTimezone || zones[1].value("TZID") != "W. Europe Standard Time" {
+		t.Fatalf("expected the generated zone and one copy of the referenced VTIMEZONE, got:\n%s", cal.serialize())
+	}
+}
+
+// The generated VTIMEZONE switches on the last Sundays of March and October
+// at 01:00 UTC, check that against the time zone database
+func TestBerlinVTimezoneRules(t *testing.T) {
+	for year := 2020; year <= 2035; year++ {
+		for _, month := range []time.Month{time.March, time.October} {
+			lastSunday := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+			for lastSunday.Weekday() != time.Sunday {
+				lastSunday = lastSunday.AddDate(0, 0, -1)
+			}
+			switchAt := lastSunday.Add(time.Hour)
+			_, before := switchAt.Add(-time.Second).In(berlin).Zone()
+			_, after := switchAt.In(berlin).Zone()
+			if before == after {
+				t.Errorf("no switch at %v", switchAt)
+			}
+		}
+	}
+	serialized := berlinVTimezone.serialize()
+	for _, want := range []string{"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU", "RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU"} {
+		if !strings.Contains(serialized, want) {
+			t.Errorf("VTIMEZONE lacks %s", want)
+		}
+	}
+}
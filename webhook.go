@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	deliveriesFile     = "deliveries.json"
+	maxDeliveries      = 100
+	defaultRetries     = 5
+	defaultBackoff     = 10 * time.Second
+	maxBackoff         = time.Hour
+	webhookTimeout     = 10 * time.Second
+	webhookSignature   = "X-Tucan-Signature"
+	webhookEventHeader = "X-Tucan-Event"
+	webhookIDHeader    = "X-Tucan-Delivery"
+	webhookTimeHeader  = "X-Tucan-Timestamp"
+)
+
+// webhookConfig is a URL receiving every notification as a signed JSON POST
+type webhookConfig struct {
+	URL        string   `yaml:"url"`
+	Secret     secret   `yaml:"secret,omitempty"`
+	SecretFile string   `yaml:"secret_file,omitempty"`
+	Events     []string `yaml:"events,omitempty"`
+	Retries    *int     `yaml:"retries,omitempty"`
+	Backoff    duration `yaml:"backoff,omitempty"`
+}
+
+// webhookNotifier posts notifications to a URL. With a secret the timestamp
+// and body are signed with HMAC-SHA256 in the X-Tucan-Signature header as
+// "sha256=<hex>".
+// Failed deliveries are retried with exponential backoff.
+type webhookNotifier struct {
+	url        string
+	secret     *secretValue
+	events     []string
+	retries    int
+	backoff    time.Duration
+	client     *http.Client
+	deliveries *deliveryLog
+}
+
+// delivery is the outcome of sending one notification to one webhook
+type delivery struct {
+	ID           string    `json:"id"`
+	Notification string    `json:"notification"`
+	Type         string    `json:"type"`
+	URL          string    `json:"url"`
+	Time         time.Time `json:"time"`
+	Attempts     int       `json:"attempts"`
+	Status       int       `json:"status,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Delivered    bool      `json:"delivered"`
+}
+
+// deliveryLog keeps the latest webhook deliveries of an account
+type deliveryLog struct {
+	mu         sync.Mutex
+	path       string
+	loaded     bool
+	deliveries []delivery
+}
+
+func newDeliveryLog(path string) *deliveryLog {
+	return &deliveryLog{path: path}
+}
+
+func newWebhookNotifier(cfg webhookConfig, deliveries *deliveryLog) *webhookNotifier {
+	w := &webhookNotifier{
+		url:        cfg.URL,
+		secret:     newSecretValue(string(cfg.Secret), cfg.SecretFile),
+		events:     cfg.Events,
+		retries:    defaultRetries,
+		backoff:    time.Duration(cfg.Backoff),
+		client:     &http.Client{Timeout: webhookTimeout},
+		deliveries: deliveries,
+	}
+	if cfg.Retries != nil {
+		w.retries = *cfg.Retries
+	}
+	if w.backoff == 0 {
+		w.backoff = defaultBackoff
+	}
+	return w
+}
+
+func (w *webhookNotifier) notify(n notification) error {
+	if !wantsNotification(w.events, n) {
+		return nil
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	d := delivery{
+		ID:           newJobID(),
+		Notification: n.ID,
+		Type:         n.Type,
+		URL:          redactURL(w.url),
+		Time:         time.Now(),
+	}
+	backoff := w.backoff
+	for {
+		d.Attempts++
+		var retry bool
+		d.Status, retry, err = w.post(d.ID, n.Type, body)
+		if err == nil {
+			d.Delivered = true
+			d.Error = ""
+			break
+		}
+		d.Error = err.Error()
+		if !retry || d.Attempts > w.retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBackoff)
+	}
+
+	if logErr := w.deliveries.add(d); logErr != nil {
+		return fmt.Errorf("failed to save the delivery log: %w", logErr)
+	}
+	if !d.Delivered {
+		return fmt.Errorf("webhook %s failed after %d attempt(s): %s", d.URL, d.Attempts, d.Error)
+	}
+	return nil
+}
+
+// Post the body once. It reports whether a failure is worth retrying, client
+// errors other than 408 and 429 won't go away by trying again.
+func (w *webhookNotifier) post(id, kind string, body []byte) (int, bool, error) {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tucan-ical")
+	req.Header.Set(webhookEventHeader, kind)
+	req.Header.Set(webhookIDHeader, id)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(webhookTimeHeader, timestamp)
+	if key := w.secret.get(); key != "" {
+		req.Header.Set(webhookSignature, signWebhook(key, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return resp.StatusCode, retry, errors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+}
+
+// Sign "<timestamp>.<body>" like Stripe does. Receivers compare it in
+// constant time and reject old timestamps, so a delivery can't be replayed.
+func signWebhook(key, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Only keep scheme and host, the path of many webhook URLs contains a secret
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (l *deliveryLog) add(d delivery) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return err
+	}
+	l.deliveries = append(l.deliveries, d)
+	if len(l.deliveries) > maxDeliveries {
+		l.deliveries = l.deliveries[len(l.deliveries)-maxDeliveries:]
+	}
+	data, err := json.MarshalIndent(l.deliveries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, data)
+}
+
+// Return the logged deliveries, oldest first
+func (l *deliveryLog) list() ([]delivery, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return append([]delivery{}, l.deliveries...), nil
+}
+
+func (l *deliveryLog) load() error {
+	if l.loaded {
+		return nil
+	}
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		l.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &l.deliveries); err != nil {
+		return fmt.Errorf("%s: %w", l.path, err)
+	}
+	l.loaded = true
+	return nil
+}
+
+// Check the webhooks of a config section, field is e.g. "webhooks"
+func validateWebhooks(field string, webhooks []webhookConfig) []error {
+	var errs []error
+	for i, hook := range webhooks {
+		name := fmt.Sprintf("%s[%d]", field, i)
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.url: must be an http or https URL", name))
+		}
+		if hook.Secret != "" && hook.SecretFile != "" {
+			errs = append(errs, fmt.Errorf("%s.secret: set either secret or secret_file, not both", name))
+		} else if hook.SecretFile != "" {
+			if _, err := readSecretFile(hook.SecretFile); err != nil {
+				errs = append(errs, fmt.Errorf("%s.secret_file: %w", name, err))
+			}
+		}
+		if err := validNotificationTypes(hook.Events); err != nil {
+			errs = append(errs, fmt.Errorf("%s.events: %w", name, err))
+		}
+		if hook.Retries != nil && *hook.Retries < 0 {
+			errs = append(errs, fmt.Errorf("%s.retries: must not be negative", name))
+		}
+		if hook.Backoff < 0 {
+			errs = append(errs, fmt.Errorf("%s.backoff: must not be negative", name))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testWebhook(t *testing.T, url string, retries int) (*webhookNotifier, *deliveryLog) {
+	t.Helper()
+	deliveries := newDeliveryLog(filepath.Join(t.TempDir(), deliveriesFile))
+	hook := newWebhookNotifier(webhookConfig{
+		URL:     url,
+		Secret:  "webhook-secret",
+		Retries: &retries,
+		Backoff: duration(time.Millisecond),
+	}, deliveries)
+	return hook, deliveries
+}
+
+func TestWebhookSignsPayload(t *testing.T) {
+	var received notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(webhookTimeHeader)
+		if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+			t.Errorf("invalid timestamp %q", timestamp)
+		}
+		want := signWebhook("webhook-secret", timestamp, body)
+		if !hmac.Equal([]byte(r.Header.Get(webhookSignature)), []byte(want)) {
+			t.Errorf("signature %q, want %q", r.Header.Get(webhookSignature), want)
+		}
+		// The signature covers the timestamp, an old delivery can't be resent as a new one
+		if replayed := signWebhook("webhook-secret", "1700000000", body); replayed == want {
+			t.Error("signature doesn't depend on the timestamp")
+		}
+		if r.Header.Get(webhookEventHeader) != notifyChanges || r.Header.Get(webhookIDHeader) == "" {
+			t.Errorf("missing event or delivery header: %v", r.Header)
+		}
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Errorf("invalid payload %q: %v", body, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	hook, deliveries := testWebhook(t, server.URL+"/hooks/secret-path", 0)
+	n := newNotification(notifyChanges, "alice")
+	n.Changes = []eventChange{{Type: changeRoom, Summary: "Analysis I", Location: "S2|02 C110", OldLocation: "S1|01 A1"}}
+	if err := hook.notify(n); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+	if received.ID != n.ID || received.Account != "alice" || len(received.Changes) != 1 || received.Changes[0].OldLocation != "S1|01 A1" {
+		t.Fatalf("unexpected payload %+v", received)
+	}
+
+	logged, err := deliveries.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logged) != 1 || !logged[0].Delivered || logged[0].Status != http.StatusNoContent || logged[0].Attempts != 1 {
+		t.Fatalf("unexpected delivery log %+v", logged)
+	}
+	if strings.Contains(logged[0].URL, "secret-path") {
+		t.Fatalf("delivery log contains the URL path: %s", logged[0].URL)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	hook, deliveries := testWebhook(t, server.URL, 5)
+	if err := hook.notify(newNotification(notifyLoginFailed, "alice")); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+	logged, _ := deliveries.list()
+	if calls.Load() != 3 || len(logged) != 1 || logged[0].Attempts != 3 || !logged[0].Delivered {
+		t.Fatalf("expected delivery on the third attempt, got %d calls and %+v", calls.Load(), logged)
+	}
+
+	// Reloading the log from disk keeps the deliveries
+	reloaded, err := newDeliveryLog(deliveries.path).list()
+	if err != nil || len(reloaded) != 1 {
+		t.Fatalf("unexpected reloaded log %+v, %v", reloaded, err)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	hook, deliveries := testWebhook(t, server.URL, 2)
+	if err := hook.notify(newNotification(notifyChanges, "alice")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 1 attempt and 2 retries, got %d calls", calls.Load())
+	}
+
+	// Client errors aren't retried
+	calls.Store(0)
+	status = http.StatusBadRequest
+	if err := hook.notify(newNotification(notifyChanges, "alice")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected no retry after 400, got %d calls", calls.Load())
+	}
+
+	logged, _ := deliveries.list()
+	if len(logged) != 2 || logged[0].Delivered || logged[1].Status != http.StatusBadRequest || logged[1].Error == "" {
+		t.Fatalf("unexpected delivery log %+v", logged)
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	hook, _ := testWebhook(t, server.URL, 0)
+	hook.events = []string{notifyLoginFailed}
+	hook.notify(newNotification(notifyChanges, "alice"))
+	hook.notify(newNotification(notifyLoginFailed, "alice"))
+	if calls.Load() != 1 {
+		t.Fatalf("expected only the subscribed event, got %d calls", calls.Load())
+	}
+}
+
+func TestLoadConfigWebhooks(t *testing.T) {
+	path := writeTestConfig(t, `
+webhooks:
+  - url: ftp://example.org
+    events: [changes, lunch]
+accounts:
+  - name: alice
+    username: ab12cdef
+    password: secret
+    totp: JBSWY3DPEHPK3PXP
+    totp_id: TOTP0001
+    webhooks:
+      - url: https://example.org/hook
+        secret: a
+        secret_file: /nonexistent
+        retries: -1
+`)
+	_, err := loadConfig(path, testEnv(nil))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"webhooks[0].url: must be an http or https URL",
+		`webhooks[0].events: unknown event "lunch"`,
+		"accounts[0].webhooks[0].secret: set either secret or secret_file",
+		"accounts[0].webhooks[0].retries: must not be negative",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+
+	// Global webhooks from the environment apply to every account
+	cfg, err := loadConfig("", testEnv(map[string]string{
+		"TUCAN_USERNAME": "ab12cdef",
+		"TUCAN_PASSWORD": "secret",
+		"TUCAN_TOTP":     "JBSWY3DPEHPK3PXP",
+		"TUCAN_TOTP_ID":  "TOTP0001",
+		"WEBHOOK_URLS":   "https://example.org/a, https://example.org/b",
+		"WEBHOOK_SECRET": "shared",
+	}))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	accounts, err := cfg.buildAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts[0].notifiers) != 2 {
+		t.Fatalf("expected 2 webhooks, got %d", len(accounts[0].notifiers))
+	}
+	if hook := accounts[0].notifiers[1].(*webhookNotifier); hook.url != "https://example.org/b" || hook.secret.get() != "shared" {
+		t.Fatalf("unexpected webhook %+v", hook)
+	}
+}